@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Account - счет пользователя: наличные, карта, накопительный или кредитный
+type Account struct {
+	ID        uint      `gorm:"primaryKey"`
+	LedgerID  uint      `gorm:"not null;index"`
+	Name      string    `gorm:"not null"`
+	Kind      string    `gorm:"not null;check:account_kind_check,kind IN ('cash','card','savings','credit')"` //вид счета
+	Currency  string    `gorm:"size:3;not null"`                                                              //все операции по счету ведутся в этой валюте
+	CreatedAt time.Time
+}
+
+// accountBalance - остаток на счете в его валюте
+type accountBalance struct {
+	AccountID uint   `json:"account_id"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Currency  string `json:"currency"`
+	Balance   Money  `json:"balance" swaggertype:"string"`
+}
+
+// transferRequest - перевод между двумя счетами пользователя
+type transferRequest struct {
+	FromAccountID uint      `json:"from_account_id"`
+	ToAccountID   uint      `json:"to_account_id"`
+	Amount        Money     `json:"amount" swaggertype:"string"`              // списывается со счета FromAccountID
+	ToAmount      *Money    `json:"to_amount,omitempty" swaggertype:"string"` // зачисляется на ToAccountID, обязателен для счетов в разных валютах
+	Date          time.Time `json:"date"`
+	Description   *string   `json:"description"`
+}
+
+// validateAccount проверяет поля счета перед сохранением
+func validateAccount(account *Account) error {
+	account.Name = strings.TrimSpace(account.Name)
+	if account.Name == "" {
+		return errors.New("Name is required")
+	}
+	switch account.Kind {
+	case "cash", "card", "savings", "credit":
+	default:
+		return errors.New("Kind must be one of: cash, card, savings, credit")
+	}
+	currency, err := normalizeCurrency(account.Currency)
+	if err != nil {
+		return err
+	}
+	account.Currency = currency
+	return nil
+}
+
+// transactionAccount проверяет, что счет относится к книге, и
+// подставляет валюту счета в транзакцию, если она не указана
+func transactionAccount(ledgerID uint, transaction *Transaction) error {
+	if transaction.AccountID == nil {
+		return nil
+	}
+	var account Account
+	if err := db.Where("id = ? AND ledger_id = ?", *transaction.AccountID, ledgerID).First(&account).Error; err != nil {
+		return errors.New("Account not found")
+	}
+	if transaction.Currency == "" {
+		transaction.Currency = account.Currency
+	} else if !strings.EqualFold(transaction.Currency, account.Currency) {
+		return errors.New("Currency must match the account currency " + account.Currency)
+	}
+	return nil
+}
+
+// @Summary Get accounts
+// @Description List accounts of the authenticated user
+// @Tags accounts
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} Account "List of accounts"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/accounts [get]
+func GetAccounts(c *fiber.Ctx) error {
+	var accounts []Account
+	if err := db.Where("ledger_id = ?", c.Locals("ledger_id").(uint)).Order("id").Find(&accounts).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(accounts)
+}
+
+// @Summary Get an account
+// @Description Get an account by ID
+// @Tags accounts
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Account ID"
+// @Success 200 {object} Account "Account"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Account not found"
+// @Router /api/accounts/{id} [get]
+func GetAccount(c *fiber.Ctx) error {
+	var account Account
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&account).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Account not found"})
+	}
+	return c.JSON(account)
+}
+
+// @Summary Create an account
+// @Description Create a cash, card, savings or credit account for the authenticated user
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param account body Account true "Account data"
+// @Success 201 {object} Account "Created account"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/accounts [post]
+func PostAccount(c *fiber.Ctx) error {
+	account := new(Account)
+	if err := c.BodyParser(account); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	account.ID = 0
+	account.LedgerID = c.Locals("ledger_id").(uint)
+
+	if account.Currency == "" { // по умолчанию счет в базовой валюте пользователя
+		base, err := baseCurrency(c.Locals("user_id").(uint))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		account.Currency = base
+	}
+	if err := validateAccount(account); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Create(account).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(account)
+}
+
+// @Summary Update an account
+// @Description Fully update an account by ID. The currency cannot be changed once the account has transactions
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Account ID"
+// @Param account body Account true "Full account data"
+// @Success 200 {object} Account "Updated account"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Account not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/accounts/{id} [put]
+func PutAccount(c *fiber.Ctx) error {
+	var account Account
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&account).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Account not found"})
+	}
+
+	updated := new(Account)
+	if err := c.BodyParser(updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := validateAccount(updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if updated.Currency != account.Currency {
+		used, err := accountInUse(account.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if used {
+			return c.Status(400).JSON(fiber.Map{"error": "Cannot change currency of an account with transactions"})
+		}
+	}
+
+	account.Name = updated.Name
+	account.Kind = updated.Kind
+	account.Currency = updated.Currency
+	if err := db.Save(&account).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(account)
+}
+
+// @Summary Delete an account
+// @Description Delete an account by ID. Accounts with transactions cannot be deleted
+// @Tags accounts
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Account ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Account not found"
+// @Failure 409 {object} map[string]string "Account has transactions"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/accounts/{id} [delete]
+func DeleteAccount(c *fiber.Ctx) error {
+	var account Account
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&account).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Account not found"})
+	}
+	used, err := accountInUse(account.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if used {
+		return c.Status(409).JSON(fiber.Map{"error": "Account has transactions"})
+	}
+	if err := db.Delete(&account).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Account deleted successfully"})
+}
+
+// accountInUse сообщает, есть ли у счета операции, в том числе в корзине
+func accountInUse(accountID uint) (bool, error) {
+	var count int64
+	err := db.Unscoped().Model(&Transaction{}).Where("account_id = ? OR to_account_id = ?", accountID, accountID).Count(&count).Error
+	return count > 0, err
+}
+
+// @Summary Get account balances
+// @Description Balance of every account of the authenticated user in the account currency, including transfers
+// @Tags accounts
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} accountBalance "Account balances"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/accounts/balances [get]
+func GetAccountBalances(c *fiber.Ctx) error {
+	balances := []accountBalance{}
+	err := db.Table("accounts a").
+		Select("a.id AS account_id, a.name, a.kind, a.currency, COALESCE(SUM(" +
+			"CASE WHEN t.to_account_id = a.id THEN COALESCE(t.to_amount, t.amount) " + //входящий перевод
+			"WHEN t.type = 'income' THEN t.amount " +
+			"ELSE -t.amount END),0) AS balance"). //расход или исходящий перевод
+		Joins("LEFT JOIN transactions t ON (t.account_id = a.id OR t.to_account_id = a.id) AND t.deleted_at IS NULL").
+		Where("a.ledger_id = ?", c.Locals("ledger_id").(uint)).
+		Group("a.id").
+		Order("a.id").
+		Scan(&balances).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(balances)
+}
+
+// @Summary Transfer between accounts
+// @Description Move money between two accounts of the authenticated user. A transfer is stored as a single transaction of type "transfer" and does not count as income or expense in the balance
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param transfer body transferRequest true "Transfer data"
+// @Success 201 {object} Transaction "Created transfer"
+// @Header 201 {string} ETag "Transaction version"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/transfers [post]
+func PostTransfer(c *fiber.Ctx) error {
+	var req transferRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Amount must be positive"})
+	}
+	if req.FromAccountID == req.ToAccountID {
+		return c.Status(400).JSON(fiber.Map{"error": "Accounts must differ"})
+	}
+	if req.Date.IsZero() {
+		req.Date = time.Now()
+	}
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	transaction := Transaction{
+		LedgerID:    ledgerID,
+		CreatedByID: c.Locals("user_id").(uint),
+		Type:        "transfer",
+		Amount:      req.Amount,
+		AccountID:   &req.FromAccountID,
+		ToAccountID: &req.ToAccountID,
+		Description: req.Description,
+		Date:        req.Date,
+	}
+
+	// счета блокируются до записи перевода, чтобы их нельзя было удалить или сменить валюту
+	var badRequest error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var accounts []Account
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ? AND ledger_id = ?", []uint{req.FromAccountID, req.ToAccountID}, ledgerID).
+			Find(&accounts).Error
+		if err != nil {
+			return err
+		}
+		if len(accounts) != 2 {
+			badRequest = errors.New("Account not found")
+			return badRequest
+		}
+		from, to := accounts[0], accounts[1]
+		if from.ID != req.FromAccountID {
+			from, to = to, from
+		}
+
+		transaction.Currency = from.Currency
+		if from.Currency != to.Currency {
+			if req.ToAmount == nil || *req.ToAmount <= 0 {
+				badRequest = errors.New("to_amount is required for accounts in different currencies")
+				return badRequest
+			}
+			transaction.ToAmount = req.ToAmount
+		} else if req.ToAmount != nil && *req.ToAmount != req.Amount {
+			badRequest = errors.New("to_amount must equal amount for accounts in the same currency")
+			return badRequest
+		}
+		if err := tx.Create(&transaction).Error; err != nil {
+			return err
+		}
+		return recordRevision(tx, requestActor(c), revisionCreate, nil, &transaction)
+	})
+	if badRequest != nil {
+		return c.Status(400).JSON(fiber.Map{"error": badRequest.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderETag, transactionETag(&transaction))
+	return c.Status(201).JSON(transaction)
+}
+
+// migrateTransferType снимает старое ограничение на тип транзакции без 'transfer',
+// AutoMigrate создаст его заново по описанию модели
+func migrateTransferType() error {
+	var definition string
+	err := db.Raw("SELECT pg_get_constraintdef(oid) FROM pg_constraint WHERE conname = 'type_check' AND conrelid = to_regclass('transactions')").
+		Scan(&definition).Error
+	if err != nil || definition == "" || strings.Contains(definition, "transfer") {
+		return err
+	}
+	return db.Exec("ALTER TABLE transactions DROP CONSTRAINT type_check").Error
+}
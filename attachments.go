@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// сколько файлов можно приложить к одной транзакции
+const maxTransactionAttachments = 20
+
+// типы файлов, которые можно приложить: фото и сканы чеков, документы в PDF.
+// Тип определяется по содержимому, а не по заголовку от клиента
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+	"image/gif":       true,
+	"application/pdf": true,
+}
+
+// Attachment - файл, приложенный к транзакции (чек, гарантийный талон, договор).
+// Содержимое лежит в blobStorage по ключу StorageKey
+type Attachment struct {
+	ID            uint         `gorm:"primaryKey"`
+	TransactionID uint         `gorm:"not null;index"`
+	Transaction   *Transaction `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	FileName      string       `gorm:"size:255;not null"`
+	ContentType   string       `gorm:"size:100;not null"`
+	Size          int64        `gorm:"not null"` //размер в байтах
+	StorageKey    string       `gorm:"size:255;not null;uniqueIndex" json:"-"`
+	UploadedByID  uint         `gorm:"not null"`
+	CreatedAt     time.Time
+}
+
+// attachmentMaxSize - наибольший размер вложения в байтах (ATTACHMENT_MAX_MB, по умолчанию 10 МБ)
+func attachmentMaxSize() int {
+	return envLimit("ATTACHMENT_MAX_MB", 10) << 20
+}
+
+// limitBody ограничивает размер тела запроса: large для маршрутов, где isLarge возвращает
+// true, small для остальных. Тело читается потоком (StreamRequestBody), поэтому запрос
+// с большим Content-Length отклоняется до чтения тела
+func limitBody(small, large int, isLarge func(c *fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := small
+		if isLarge(c) {
+			limit = large
+		}
+		// без Content-Length (chunked) размер известен только после чтения. Допустимое тело
+		// читается сразу целиком: непрочитанный остаток соединение приняло бы за следующий запрос
+		n := c.Request().Header.ContentLength()
+		if n <= limit {
+			n = len(c.Body())
+		}
+		if n > limit {
+			c.Context().SetConnectionClose()
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "request body must be at most " + strconv.Itoa(limit>>20) + " MB"})
+		}
+		return c.Next()
+	}
+}
+
+// isAttachmentUpload - маршрут загрузки вложения, единственный с большим телом запроса
+func isAttachmentUpload(c *fiber.Ctx) bool {
+	return c.Method() == fiber.MethodPost && attachmentUploadPath.MatchString(c.Path())
+}
+
+var attachmentUploadPath = regexp.MustCompile(`(?i)^/api/transactions/[^/]+/attachments/?$`)
+
+// attachmentFileName оставляет от имени файла клиента только базовое имя без управляющих символов
+func attachmentFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[:255])
+	}
+	return name
+}
+
+// deleteBlobs удаляет файлы вложений после удаления записей из бд; ошибки только пишутся в лог,
+// потому что записи уже удалены и вернуть их нельзя
+func deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := blobStorage.Delete(key); err != nil {
+			log.Println("Ошибка удаления файла вложения", key+":", err)
+		}
+	}
+}
+
+// attachmentTransaction находит транзакцию текущей книги по id из пути
+func attachmentTransaction(c *fiber.Ctx) (*Transaction, error) {
+	var transaction Transaction
+	err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&transaction).Error
+	return &transaction, err
+}
+
+// @Summary List transaction attachments
+// @Description Files attached to a transaction, without their contents
+// @Tags attachments
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Success 200 {array} Attachment "Attachments"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Transaction not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/transactions/{id}/attachments [get]
+func GetAttachments(c *fiber.Ctx) error {
+	transaction, err := attachmentTransaction(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+	var attachments []Attachment
+	if err := db.Where("transaction_id = ?", transaction.ID).Order("id").Find(&attachments).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(attachments)
+}
+
+// @Summary Upload an attachment
+// @Description Attach a receipt or document to a transaction. Allowed types: JPEG, PNG, WebP, GIF and PDF, detected from the file contents. The size limit is 10 MB unless configured otherwise
+// @Tags attachments
+// @Accept mpfd
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Param file formData file true "File"
+// @Success 201 {object} Attachment "Uploaded attachment"
+// @Failure 400 {object} map[string]string "Missing file, unsupported type or too many attachments"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Transaction not found"
+// @Failure 413 {object} map[string]string "File too large"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/transactions/{id}/attachments [post]
+func PostAttachment(c *fiber.Ctx) error {
+	transaction, err := attachmentTransaction(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "file is required"})
+	}
+	maxSize := attachmentMaxSize()
+	tooLarge := fiber.Map{"error": "file must be at most " + strconv.Itoa(maxSize>>20) + " MB"}
+	if header.Size > int64(maxSize) {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(tooLarge)
+	}
+
+	var count int64
+	if err := db.Model(&Attachment{}).Where("transaction_id = ?", transaction.ID).Count(&count).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if count >= maxTransactionAttachments {
+		return c.Status(400).JSON(fiber.Map{"error": "A transaction can have at most " + strconv.Itoa(maxTransactionAttachments) + " attachments"})
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxSize)+1))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(data) > maxSize {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(tooLarge)
+	}
+	if len(data) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "file is empty"})
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !attachmentTypes[contentType] {
+		return c.Status(400).JSON(fiber.Map{"error": "unsupported file type " + contentType + ", expected JPEG, PNG, WebP, GIF or PDF"})
+	}
+
+	attachment := Attachment{
+		TransactionID: transaction.ID,
+		FileName:      attachmentFileName(header.Filename),
+		ContentType:   contentType,
+		Size:          int64(len(data)),
+		StorageKey:    "ledgers/" + strconv.FormatUint(uint64(transaction.LedgerID), 10) + "/" + randomID(),
+		UploadedByID:  c.Locals("user_id").(uint),
+	}
+	// сначала файл, потом запись: запись без файла была бы битой ссылкой
+	if err := blobStorage.Put(attachment.StorageKey, attachment.ContentType, data); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Create(&attachment).Error; err != nil {
+		deleteBlobs([]string{attachment.StorageKey})
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(attachment)
+}
+
+// @Summary Download an attachment
+// @Description Download the contents of an attached file
+// @Tags attachments
+// @Produce application/octet-stream
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Param attachment_id path int true "Attachment ID"
+// @Success 200 {file} file "File contents"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Attachment not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/transactions/{id}/attachments/{attachment_id} [get]
+func GetAttachment(c *fiber.Ctx) error {
+	transaction, err := attachmentTransaction(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Attachment not found"})
+	}
+	var attachment Attachment
+	if err := db.Where("id = ? AND transaction_id = ?", c.Params("attachment_id"), transaction.ID).First(&attachment).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Attachment not found"})
+	}
+	content, err := blobStorage.Get(attachment.StorageKey)
+	if errors.Is(err, errBlobNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Attachment file is missing"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, attachment.ContentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	return c.SendStream(content, int(attachment.Size)) //поток закрывается после отправки
+}
+
+// @Summary Delete an attachment
+// @Description Delete an attached file together with its contents
+// @Tags attachments
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Param attachment_id path int true "Attachment ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Attachment not found"
+// @Router /api/transactions/{id}/attachments/{attachment_id} [delete]
+func DeleteAttachment(c *fiber.Ctx) error {
+	transaction, err := attachmentTransaction(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Attachment not found"})
+	}
+	var attachment Attachment
+	if err := db.Where("id = ? AND transaction_id = ?", c.Params("attachment_id"), transaction.ID).First(&attachment).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Attachment not found"})
+	}
+	if err := db.Delete(&attachment).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	deleteBlobs([]string{attachment.StorageKey})
+	return c.JSON(fiber.Map{"message": "Attachment deleted successfully"})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLimitBody(t *testing.T) {
+	app := fiber.New(fiber.Config{BodyLimit: 4 << 20, StreamRequestBody: true})
+	app.Use(limitBody(1<<20, 2<<20, isAttachmentUpload))
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") } // тело не читается, его уже прочитал limitBody
+	app.Post("/api/transactions/:id", ok)
+	app.Post("/api/transactions/:id/attachments", ok)
+	app.Get("/api/transactions/:id/attachments", ok)
+
+	tests := []struct {
+		method, path string
+		size         int
+		chunked      bool
+		want         int
+	}{
+		{method: "POST", path: "/api/transactions/1", size: 1 << 20, want: 200},
+		{method: "POST", path: "/api/transactions/1", size: 1<<20 + 1, want: fiber.StatusRequestEntityTooLarge},
+		{method: "POST", path: "/api/transactions/1", size: 1<<20 + 1, chunked: true, want: fiber.StatusRequestEntityTooLarge},
+		{method: "POST", path: "/api/transactions/1/attachments", size: 2 << 20, want: 200},
+		{method: "POST", path: "/API/Transactions/1/Attachments/", size: 2 << 20, want: 200},
+		{method: "POST", path: "/api/transactions/1/attachments", size: 2<<20 + 1, want: fiber.StatusRequestEntityTooLarge},
+		{method: "POST", path: "/api/transactions/1/attachments", size: 2<<20 + 1, chunked: true, want: fiber.StatusRequestEntityTooLarge},
+		{method: "GET", path: "/api/transactions/1/attachments", size: 1<<20 + 1, want: fiber.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(strings.Repeat("a", tt.size)))
+		if tt.chunked {
+			req.ContentLength, req.TransferEncoding = -1, []string{"chunked"}
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s %s chunked %v: %v", tt.method, tt.path, tt.chunked, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s with %d bytes (chunked %v): status %d, want %d", tt.method, tt.path, tt.size, tt.chunked, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// действия, которые попадают в историю транзакций
+const (
+	revisionCreate  = "create"
+	revisionUpdate  = "update"
+	revisionDelete  = "delete"  // перемещение в корзину
+	revisionRestore = "restore" // восстановление из корзины
+	revisionPurge   = "purge"   // окончательное удаление после срока хранения в корзине
+)
+
+var revisionActions = []string{revisionCreate, revisionUpdate, revisionDelete, revisionRestore, revisionPurge}
+
+// jsonSnapshot - состояние транзакции в JSON, в бд хранится в колонке jsonb
+type jsonSnapshot []byte
+
+func (s jsonSnapshot) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return []byte("null"), nil
+	}
+	return s, nil
+}
+
+func (s jsonSnapshot) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	return string(s), nil
+}
+
+func (s *jsonSnapshot) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+	case string:
+		*s = jsonSnapshot(v)
+	case []byte:
+		*s = append(jsonSnapshot(nil), v...)
+	default:
+		return fmt.Errorf("snapshot: cannot scan %T", src)
+	}
+	return nil
+}
+
+// GormDataType задает тип колонки для AutoMigrate
+func (jsonSnapshot) GormDataType() string {
+	return "jsonb"
+}
+
+// TransactionRevision - запись истории изменений транзакции. Записи только добавляются,
+// у транзакции нет внешнего ключа, чтобы история оставалась после ее удаления
+type TransactionRevision struct {
+	ID            uint         `gorm:"primaryKey"`
+	TransactionID uint         `gorm:"not null;index"`
+	LedgerID      uint         `gorm:"not null;index:idx_revision_ledger,priority:1"`
+	ActorID       *uint        `gorm:"index"` //кто изменил, nil - фоновые задачи (регулярные операции, очистка корзины)
+	Actor         *User        `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	Action        string       `gorm:"size:10;not null;check:revision_action_check,action IN ('create','update','delete','restore','purge')"`
+	Before        jsonSnapshot `swaggertype:"object"` //nil при создании
+	After         jsonSnapshot `swaggertype:"object"` //nil при окончательном удалении
+	RequestID     string       `gorm:"size:64"`       //X-Request-ID запроса, в котором сделано изменение
+	CreatedAt     time.Time    `gorm:"index:idx_revision_ledger,priority:2"`
+}
+
+// revisionResponse - запись истории с адресом автора изменения
+type revisionResponse struct {
+	ID            uint         `json:"id"`
+	TransactionID uint         `json:"transaction_id"`
+	Action        string       `json:"action" enums:"create,update,delete,restore,purge"`
+	ActorID       *uint        `json:"actor_id"` // null - фоновые задачи: регулярные операции, очистка корзины
+	ActorEmail    *string      `json:"actor_email"`
+	RequestID     string       `json:"request_id"`
+	Before        jsonSnapshot `json:"before" swaggertype:"object"` // null при создании
+	After         jsonSnapshot `json:"after" swaggertype:"object"`  // null при окончательном удалении
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+// revisionPage - страница ленты изменений
+type revisionPage struct {
+	Items      []revisionResponse `json:"items"`
+	NextCursor string             `json:"next_cursor,omitempty"` // пустой, если записей больше нет
+}
+
+// revisionActor - кто и в каком запросе меняет транзакции
+type revisionActor struct {
+	UserID    *uint
+	RequestID string
+}
+
+// requestActor - автор изменений из контекста запроса
+func requestActor(c *fiber.Ctx) revisionActor {
+	actor := revisionActor{}
+	if userID, ok := c.Locals("user_id").(uint); ok {
+		actor.UserID = &userID
+	}
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		if len(requestID) > 64 { //идентификатор может прийти от клиента в заголовке X-Request-ID
+			requestID = requestID[:64]
+		}
+		actor.RequestID = requestID
+	}
+	return actor
+}
+
+// transactionSnapshot сохраняет транзакцию с разбивкой и тегами; вложенные категории
+// не сохраняются, они описывают текущее состояние категорий, а не момент изменения
+func transactionSnapshot(t *Transaction) (jsonSnapshot, error) {
+	copied := *t
+	copied.Category = nil
+	copied.Splits = make([]TransactionSplit, len(t.Splits))
+	for i, split := range t.Splits {
+		split.Category = nil
+		copied.Splits[i] = split
+	}
+	return json.Marshal(copied)
+}
+
+// loadTransactionState загружает транзакцию книги вместе с тем, что попадает в историю
+func loadTransactionState(tx *gorm.DB, id interface{}, ledgerID uint) (*Transaction, error) {
+	var transaction Transaction
+	err := tx.Preload("Splits", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Tags", func(tx *gorm.DB) *gorm.DB { return tx.Order("name") }).
+		Where("id = ? AND ledger_id = ?", id, ledgerID).
+		First(&transaction).Error
+	return &transaction, err
+}
+
+// newRevision строит запись истории; before равен nil при создании, after - при удалении
+func newRevision(actor revisionActor, action string, before, after *Transaction) (*TransactionRevision, error) {
+	revision := &TransactionRevision{ActorID: actor.UserID, Action: action, RequestID: actor.RequestID}
+	var err error
+	if before != nil {
+		revision.TransactionID, revision.LedgerID = before.ID, before.LedgerID
+		if revision.Before, err = transactionSnapshot(before); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		revision.TransactionID, revision.LedgerID = after.ID, after.LedgerID
+		if revision.After, err = transactionSnapshot(after); err != nil {
+			return nil, err
+		}
+	}
+	return revision, nil
+}
+
+// recordRevision добавляет запись в историю в той же транзакции бд, что и само изменение
+func recordRevision(tx *gorm.DB, actor revisionActor, action string, before, after *Transaction) error {
+	revision, err := newRevision(actor, action, before, after)
+	if err != nil {
+		return err
+	}
+	return tx.Create(revision).Error
+}
+
+// revisionQuery - записи истории книги с адресом автора
+func revisionQuery(ledgerID uint) *gorm.DB {
+	return db.Table("transaction_revisions AS r").
+		Joins("LEFT JOIN users ON users.id = r.actor_id").
+		Select("r.id, r.transaction_id, r.action, r.actor_id, users.email AS actor_email, r.request_id, r.before, r.after, r.created_at").
+		Where("r.ledger_id = ?", ledgerID)
+}
+
+// @Summary Get transaction history
+// @Description Every change of a transaction in chronological order: who made it, when, in which request (X-Request-ID) and the state before and after. The history is kept after the transaction is deleted
+// @Tags audit
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Success 200 {array} revisionResponse "Revisions"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Transaction not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/transactions/{id}/history [get]
+func GetTransactionHistory(c *fiber.Ctx) error {
+	var revisions []revisionResponse
+	err := revisionQuery(c.Locals("ledger_id").(uint)).
+		Where("r.transaction_id = ?", c.Params("id")).
+		Order("r.id").
+		Scan(&revisions).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(revisions) == 0 { // транзакции, созданные до ведения истории, ее не имеют
+		var count int64
+		err := db.Model(&Transaction{}).Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).Count(&count).Error
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if count == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+		}
+		revisions = []revisionResponse{}
+	}
+	return c.JSON(revisions)
+}
+
+// @Summary Get audit log
+// @Description Feed of transaction changes in the current ledger, newest first, with cursor-based pagination
+// @Tags audit
+// @Produce json
+// @Security ApiKeyAuth
+// @Param actor_id query int false "Only changes made by this user"
+// @Param action query string false "Only this kind of change" Enums(create, update, delete, restore, purge)
+// @Param from query string false "Start date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param limit query int false "Page size (1-200)" default(50)
+// @Param cursor query string false "Cursor from next_cursor of the previous page"
+// @Success 200 {object} revisionPage "Page of changes"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not available with a personal access token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/audit [get]
+func GetAuditLog(c *fiber.Ctx) error {
+	query := revisionQuery(c.Locals("ledger_id").(uint))
+
+	actorID, err := parseIDParam(c, "actor_id")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if actorID != nil {
+		query = query.Where("r.actor_id = ?", *actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		if !slices.Contains(revisionActions, action) {
+			return c.Status(400).JSON(fiber.Map{"error": "action must be one of: " + strings.Join(revisionActions, ", ")})
+		}
+		query = query.Where("r.action = ?", action)
+	}
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if from != nil {
+		query = query.Where("r.created_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("r.created_at < ?", *to)
+	}
+
+	limit := defaultPageSize
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return c.Status(400).JSON(fiber.Map{"error": "limit must be between 1 and " + strconv.Itoa(maxPageSize)})
+		}
+	}
+	if v := c.Query("cursor"); v != "" { // курсор - id последней выданной записи
+		cursor, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid cursor"})
+		}
+		query = query.Where("r.id < ?", cursor)
+	}
+
+	page := revisionPage{Items: []revisionResponse{}}
+	if err := query.Order("r.id DESC").Limit(limit + 1).Scan(&page.Items).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(page.Items) > limit { //есть следующая страница
+		page.Items = page.Items[:limit]
+		page.NextCursor = strconv.FormatUint(uint64(page.Items[limit-1].ID), 10)
+	}
+	return c.JSON(page)
+}
+
+// migrateRevisionActions снимает ограничение на действие без 'restore' и 'purge',
+// AutoMigrate создаст его заново по описанию модели
+func migrateRevisionActions() error {
+	var definition string
+	err := db.Raw("SELECT pg_get_constraintdef(oid) FROM pg_constraint WHERE conname = 'revision_action_check' AND conrelid = to_regclass('transaction_revisions')").
+		Scan(&definition).Error
+	if err != nil || definition == "" || strings.Contains(definition, "purge") {
+		return err
+	}
+	return db.Exec("ALTER TABLE transaction_revisions DROP CONSTRAINT revision_action_check").Error
+}
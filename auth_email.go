@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// UserToken - одноразовый токен из письма: подтверждение email или сброс пароля.
+// В бд хранится только хеш
+type UserToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	User      *User     `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Purpose   string    `gorm:"size:20;not null;check:user_token_purpose_check,purpose IN ('verify_email','reset_password')"`
+	TokenHash string    `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// emailRequest - тело запросов, где нужен только email
+type emailRequest struct {
+	Email string `json:"email"`
+}
+
+// tokenRequest - тело запроса подтверждения email
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+// resetPasswordRequest - тело запроса сброса пароля
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+var errTokenInvalid = errors.New("invalid or expired token")
+
+// normalizeEmail приводит адрес к нижнему регистру и проверяет формат
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" { //только голый адрес, без имени и <>
+		return "", errors.New("invalid email address")
+	}
+	if !strings.Contains(email[strings.LastIndexByte(email, '@')+1:], ".") {
+		return "", errors.New("invalid email address")
+	}
+	return email, nil
+}
+
+// validatePassword проверяет длину пароля; bcrypt учитывает только первые 72 байта
+func validatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if len(password) > 72 {
+		return errors.New("password must be at most 72 bytes")
+	}
+	return nil
+}
+
+// appLink - ссылка на страницу клиента из письма
+func appLink(path, token string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimRight(base, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// createUserToken создает токен для письма; прежние неиспользованные токены того же назначения гасятся
+func createUserToken(tx *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	err := tx.Model(&UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error
+	if err != nil {
+		return "", err
+	}
+	token := randomToken(32)
+	return token, tx.Create(&UserToken{UserID: userID, Purpose: purpose, TokenHash: hashToken(token), ExpiresAt: now.Add(ttl)}).Error
+}
+
+// useUserToken находит действующий токен и помечает его использованным
+func useUserToken(tx *gorm.DB, token, purpose string) (*UserToken, error) {
+	var userToken UserToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), purpose, time.Now()).
+		First(&userToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	userToken.UsedAt = &now
+	return &userToken, tx.Save(&userToken).Error
+}
+
+// sendVerificationEmail отправляет письмо со ссылкой подтверждения адреса
+func sendVerificationEmail(user *User) error {
+	token, err := createUserToken(db, user.ID, "verify_email", verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	body := "Здравствуйте!\n\nПодтвердите адрес электронной почты для Finance Tracker по ссылке:\n" +
+		appLink("/verify-email", token) + "\n\nКод подтверждения: " + token +
+		"\n\nСсылка действует 48 часов. Если вы не регистрировались, просто удалите это письмо.\n"
+	return mailer.Send(user.Email, "Подтверждение email", body)
+}
+
+// sendAlreadyRegisteredEmail сообщает владельцу адреса о попытке повторной регистрации,
+// чтобы ответ /auth/register не выдавал, какие email уже заняты
+func sendAlreadyRegisteredEmail(email string) error {
+	body := "Здравствуйте!\n\nКто-то попытался зарегистрироваться в Finance Tracker с этим адресом, но аккаунт уже существует.\n" +
+		"Если вы забыли пароль, воспользуйтесь восстановлением пароля на странице входа.\n\n" +
+		"Если это были не вы, просто удалите это письмо.\n"
+	return mailer.Send(email, "Аккаунт уже зарегистрирован", body)
+}
+
+// @Summary Verify email
+// @Description Confirm ownership of the email address with the token sent after registration
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body tokenRequest true "Verification token"
+// @Success 200 {object} map[string]string "Email verified"
+// @Failure 400 {object} map[string]string "Invalid or expired token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/verify-email [post]
+func VerifyEmail(c *fiber.Ctx) error {
+	var req tokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		token, err := useUserToken(tx, req.Token, "verify_email")
+		if err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ? AND email_verified_at IS NULL", token.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+	if errors.Is(err, errTokenInvalid) {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "email verified"})
+}
+
+// @Summary Resend verification email
+// @Description Send a new verification email. The response is the same whether or not the address is registered
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body emailRequest true "Email"
+// @Success 200 {object} map[string]string "Request accepted"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Router /auth/verify-email/resend [post]
+func ResendVerificationEmail(c *fiber.Ctx) error {
+	var req emailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	if email, err := normalizeEmail(req.Email); err == nil {
+		var user User
+		if db.Where("email = ? AND email_verified_at IS NULL", email).First(&user).Error == nil {
+			go func() { //в фоне, чтобы время ответа не выдавало зарегистрированные адреса
+				if err := sendVerificationEmail(&user); err != nil {
+					log.Println("Ошибка отправки письма подтверждения:", err)
+				}
+			}()
+		}
+	}
+	return c.JSON(fiber.Map{"message": "if the address is registered and not verified, a new email has been sent"})
+}
+
+// @Summary Request password reset
+// @Description Send a password reset link to the email. The response is the same whether or not the address is registered
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body emailRequest true "Email"
+// @Success 200 {object} map[string]string "Request accepted"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Router /auth/password/forgot [post]
+func ForgotPassword(c *fiber.Ctx) error {
+	var req emailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	if email, err := normalizeEmail(req.Email); err == nil {
+		var user User
+		if db.Where("email = ?", email).First(&user).Error == nil {
+			go func() { //в фоне, чтобы время ответа не выдавало зарегистрированные адреса
+				token, err := createUserToken(db, user.ID, "reset_password", resetPasswordTTL)
+				if err == nil {
+					body := "Здравствуйте!\n\nКто-то запросил сброс пароля для Finance Tracker. Чтобы задать новый пароль, перейдите по ссылке:\n" +
+						appLink("/reset-password", token) + "\n\nКод сброса: " + token +
+						"\n\nСсылка действует 1 час. Если вы не запрашивали сброс, просто удалите это письмо.\n"
+					err = mailer.Send(user.Email, "Сброс пароля", body)
+				}
+				if err != nil {
+					log.Println("Ошибка отправки письма для сброса пароля:", err)
+				}
+			}()
+		}
+	}
+	return c.JSON(fiber.Map{"message": "if the address is registered, a reset link has been sent"})
+}
+
+// @Summary Reset password
+// @Description Set a new password using the token from the reset email. All sessions of the user are logged out
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body resetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]string "Password changed"
+// @Failure 400 {object} map[string]string "Invalid token or password"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/password/reset [post]
+func ResetPassword(c *fiber.Ctx) error {
+	var req resetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err := validatePassword(req.Password); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		token, err := useUserToken(tx, req.Token, "reset_password")
+		if err != nil {
+			return err
+		}
+		// письмо со ссылкой дошло до владельца, значит адрес тоже подтвержден
+		err = tx.Model(&User{}).Where("id = ?", token.UserID).
+			Updates(map[string]interface{}{
+				"password_hash":     GeneratePassword(req.Password),
+				"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", time.Now()),
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		// выходим из всех сессий: пароль мог быть известен злоумышленнику
+		var families []string
+		err = tx.Model(&RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", token.UserID).
+			Distinct().Pluck("family_id", &families).Error
+		if err != nil {
+			return err
+		}
+		for _, family := range families {
+			if err := revokeFamily(tx, family); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errTokenInvalid) {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "password changed"})
+}
+
+// migrateUserEmails приводит адреса существующих пользователей к нижнему регистру перед
+// созданием уникального индекса и считает их подтвержденными, чтобы не заблокировать вход
+func migrateUserEmails() error {
+	if !db.Migrator().HasTable(&User{}) {
+		return nil
+	}
+	var duplicates []string
+	err := db.Raw("SELECT LOWER(TRIM(email)) FROM users GROUP BY 1 HAVING COUNT(*) > 1").Scan(&duplicates).Error
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return errors.New("users with duplicate emails must be merged before migration: " + strings.Join(duplicates, ", "))
+	}
+
+	dataType, err := columnType("users", "email_verified_at")
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE users SET email = LOWER(TRIM(email)) WHERE email <> LOWER(TRIM(email))").Error; err != nil {
+			return err
+		}
+		if dataType != "" {
+			return nil
+		}
+		if err := tx.Exec("ALTER TABLE users ADD COLUMN email_verified_at timestamptz").Error; err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE users SET email_verified_at = NOW()").Error
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RefreshToken - refresh-токен для продления сессии. В бд хранится только хеш.
+// Токены одного входа образуют семейство: при обновлении старый токен отзывается
+// и заменяется новым, повторное использование отозванного токена отзывает все семейство
+type RefreshToken struct {
+	ID           uint      `gorm:"primaryKey"`
+	UserID       uint      `gorm:"not null;index"`
+	User         *User     `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	TokenHash    string    `gorm:"size:64;not null;uniqueIndex"` //sha256 от токена
+	FamilyID     string    `gorm:"size:32;not null;index"`       //общий для всех токенов одного входа
+	AccessJTI    string    `gorm:"size:32;not null"`             //jti access-токена, выданного вместе с этим
+	ExpiresAt    time.Time `gorm:"not null"`
+	RevokedAt    *time.Time
+	ReplacedByID *uint //токен, выданный взамен при обновлении
+	CreatedAt    time.Time
+}
+
+// RevokedToken - отозванный access-токен; хранится, пока токен не истечет сам
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:32"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// refreshRequest - тело запросов /auth/refresh и /auth/logout
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenResponse - пара токенов, выдаваемая при входе и обновлении
+type tokenResponse struct {
+	Token        string `json:"token"` // access-токен для заголовка Authorization
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // время жизни access-токена в секундах
+}
+
+var (
+	errRefreshInvalid = errors.New("invalid refresh token")
+	errRefreshExpired = errors.New("refresh token expired")
+	errRefreshReused  = errors.New("refresh token reuse detected, please log in again")
+)
+
+// tokenTTL читает время жизни токена из переменной окружения
+func tokenTTL(name string, fallback time.Duration) time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv(name)); err == nil && ttl > 0 {
+		return ttl
+	}
+	return fallback
+}
+
+func accessTokenTTL() time.Duration {
+	return tokenTTL("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+func refreshTokenTTL() time.Duration {
+	return tokenTTL("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+// randomToken возвращает случайную строку из size байт в base64url
+func randomToken(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand не возвращает ошибок на поддерживаемых платформах
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// randomID - случайный идентификатор из 32 hex-символов (jti, семейство токенов)
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// hashToken - хеш токена для хранения в бд
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens выдает access- и refresh-токен. Пустой familyID начинает новое семейство (вход)
+func issueTokens(tx *gorm.DB, userID uint, familyID string) (*tokenResponse, *RefreshToken, error) {
+	if familyID == "" {
+		familyID = randomID()
+	}
+	jti := randomID()
+	access, err := GenerateToken(userID, jti)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh := randomToken(32)
+	token := RefreshToken{
+		UserID:    userID,
+		TokenHash: hashToken(refresh),
+		FamilyID:  familyID,
+		AccessJTI: jti,
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return nil, nil, err
+	}
+	return &tokenResponse{Token: access, RefreshToken: refresh, ExpiresIn: int(accessTokenTTL().Seconds())}, &token, nil
+}
+
+// revokeFamily отзывает все refresh-токены семейства и access-токены, выданные вместе с ними
+func revokeFamily(tx *gorm.DB, familyID string) error {
+	now := time.Now()
+	var tokens []RefreshToken
+	if err := tx.Where("family_id = ?", familyID).Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	var revoked []RevokedToken
+	for _, token := range tokens {
+		if expires := token.CreatedAt.Add(accessTokenTTL()); expires.After(now) { //истекшие access-токены отзывать не нужно
+			revoked = append(revoked, RevokedToken{JTI: token.AccessJTI, ExpiresAt: expires})
+		}
+	}
+	if len(revoked) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyID).Update("revoked_at", now).Error
+}
+
+// purgeExpiredTokens удаляет истекшие refresh-токены и записи об отозванных access-токенах
+func purgeExpiredTokens() error {
+	now := time.Now()
+	if err := db.Where("expires_at < ?", now).Delete(&RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return db.Where("expires_at < ?", now).Delete(&RevokedToken{}).Error
+}
+
+// checkAccessToken - проверка после подписи: у токена есть срок действия, jti и он не отозван
+func checkAccessToken(c *fiber.Ctx) error {
+	claims := c.Locals("jwt").(*jwt.Token).Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	if _, ok := claims["exp"]; !ok || jti == "" { //токены старого формата без срока действия
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": true, "msg": "token has no expiry, please log in again"})
+	}
+	if typ, ok := claims["typ"]; ok && typ != "access" { //например, токен первого шага входа с 2FA
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": true, "msg": "not an access token"})
+	}
+
+	var count int64
+	if err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": true, "msg": err.Error()})
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": true, "msg": "token has been revoked"})
+	}
+	return c.Next()
+}
+
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and refresh token. The old refresh token becomes invalid; presenting it again revokes every token issued since the login
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body refreshRequest true "Refresh token"
+// @Success 200 {object} tokenResponse "New tokens"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]string "Invalid, expired or reused refresh token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/refresh [post]
+func RefreshTokens(c *fiber.Ctx) error {
+	var req refreshRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	if req.RefreshToken == "" {
+		return c.Status(400).JSON(fiber.Map{"message": "refresh_token is required"})
+	}
+
+	var response *tokenResponse
+	reused := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// блокировка не дает двум параллельным запросам обменять один токен дважды
+		var old RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hashToken(req.RefreshToken)).First(&old).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errRefreshInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if old.RevokedAt != nil { //токен уже обменян или отозван - вероятно, украден
+			reused = true
+			return revokeFamily(tx, old.FamilyID)
+		}
+		if time.Now().After(old.ExpiresAt) {
+			return errRefreshExpired
+		}
+
+		resp, token, err := issueTokens(tx, old.UserID, old.FamilyID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		old.RevokedAt, old.ReplacedByID = &now, &token.ID
+		response = resp
+		return tx.Save(&old).Error
+	})
+	if reused && err == nil {
+		err = errRefreshReused
+	}
+	switch {
+	case errors.Is(err, errRefreshInvalid), errors.Is(err, errRefreshExpired), errors.Is(err, errRefreshReused):
+		return c.Status(401).JSON(fiber.Map{"message": err.Error()})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(response)
+}
+
+// @Summary Logout
+// @Description Revoke the refresh token together with all tokens issued since the same login, including access tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body refreshRequest true "Refresh token"
+// @Success 200 {object} map[string]string "Logged out"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/logout [post]
+func Logout(c *fiber.Ctx) error {
+	var req refreshRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	if req.RefreshToken == "" {
+		return c.Status(400).JSON(fiber.Map{"message": "refresh_token is required"})
+	}
+
+	var token RefreshToken
+	err := db.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&token).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err == nil { //неизвестный токен - сессии уже нет, выход все равно успешен
+		if err := db.Transaction(func(tx *gorm.DB) error { return revokeFamily(tx, token.FamilyID) }); err != nil {
+			return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+		}
+	}
+	if err := purgeExpiredTokens(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "logged out"})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTokenTTL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: time.Hour},
+		{value: "5m", want: 5 * time.Minute},
+		{value: "0s", want: time.Hour},
+		{value: "-5m", want: time.Hour},
+		{value: "soon", want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_TOKEN_TTL", tt.value)
+		if got := tokenTTL("TEST_TOKEN_TTL", time.Hour); got != tt.want {
+			t.Errorf("tokenTTL(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRandomTokens(t *testing.T) {
+	if token := randomToken(32); len(token) != 43 || token == randomToken(32) {
+		t.Errorf("randomToken(32) = %q, want 43 unique base64url characters", token)
+	}
+	if id := randomID(); len(id) != 32 || id == randomID() {
+		t.Errorf("randomID() = %q, want 32 unique hex characters", id)
+	}
+	// sha256("abc")
+	if got := hashToken("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("hashToken = %s", got)
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+	t.Setenv("ACCESS_TOKEN_TTL", "10m")
+	signed, err := GenerateToken(7, "jti-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return []byte("test secret"), nil })
+	if err != nil || !token.Valid {
+		t.Fatalf("parse: %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["user_id"] != float64(7) || claims["jti"] != "jti-1" || claims["typ"] != "access" {
+		t.Errorf("claims = %v", claims)
+	}
+	exp, iat := claims["exp"].(float64), claims["iat"].(float64)
+	if exp-iat != 600 {
+		t.Errorf("exp - iat = %v, want 600", exp-iat)
+	}
+}
+
+func TestCheckAccessTokenRejectsWithoutDB(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{name: "token without expiry", claims: jwt.MapClaims{"user_id": 7, "jti": "a"}},
+		{name: "token without jti", claims: jwt.MapClaims{"user_id": 7, "exp": 1}},
+		{name: "not an access token", claims: jwt.MapClaims{"user_id": 7, "jti": "a", "exp": 1, "typ": "mfa"}},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			c.Locals("jwt", jwt.NewWithClaims(jwt.SigningMethodHS256, tt.claims))
+			return c.Next()
+		}, checkAccessToken)
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", tt.name, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// сумма в базовой валюте со знаком: доход увеличивает баланс, расход уменьшает
+const signedAmountSQL = "CASE WHEN type = 'income' THEN base_amount WHEN type = 'expense' THEN -base_amount ELSE 0 END"
+
+// balanceSummary - баланс за период
+type balanceSummary struct {
+	From           *time.Time `json:"from,omitempty"`
+	To             *time.Time `json:"to,omitempty"`
+	OpeningBalance Money      `json:"opening_balance" swaggertype:"string"` // баланс на начало периода
+	Income         Money      `json:"income" swaggertype:"string"`
+	Expense        Money      `json:"expense" swaggertype:"string"`
+	ClosingBalance Money      `json:"closing_balance" swaggertype:"string"` // баланс на конец периода
+	Balance        Money      `json:"balance" swaggertype:"string"`         // то же, что closing_balance
+	Currency       string     `json:"currency"`                             // базовая валюта пользователя
+}
+
+// balancePoint - точка ряда баланса
+type balancePoint struct {
+	Period  time.Time `json:"period"` // начало дня/недели/месяца
+	Income  Money     `json:"income" swaggertype:"string"`
+	Expense Money     `json:"expense" swaggertype:"string"`
+	Balance Money     `json:"balance" swaggertype:"string"` // баланс на конец периода
+}
+
+// parsePeriod читает границы периода из from/to или as_of.
+// Возвращаемая верхняя граница не включается в период
+func parsePeriod(c *fiber.Ctx) (from, to *time.Time, err error) {
+	if v := c.Query("from"); v != "" {
+		t, _, err := parseDateParam(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		from = &t
+	}
+
+	end := c.Query("to")
+	if v := c.Query("as_of"); v != "" {
+		if end != "" || from != nil {
+			return nil, nil, errors.New("as_of cannot be combined with from/to")
+		}
+		end = v
+	}
+	if end != "" {
+		t, dateOnly, err := parseDateParam(end)
+		if err != nil {
+			return nil, nil, err
+		}
+		if dateOnly { // дата без времени - включаем весь день
+			t = t.AddDate(0, 0, 1)
+		} else {
+			t = t.Add(time.Nanosecond)
+		}
+		to = &t
+	}
+
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+// @Summary Get balance history
+// @Description Running balance time series for the authenticated user in the base currency, grouped by day, week or month. Periods without transactions are omitted
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param interval query string false "Grouping interval" Enums(day, week, month) default(day)
+// @Param from query string false "Start date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date inclusive (YYYY-MM-DD or RFC3339)"
+// @Success 200 {array} balancePoint "Balance series"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 422 {object} map[string]string "Missing exchange rate or amount out of range"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/balance/history [get]
+func GetBalanceHistory(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	interval := c.Query("interval", "day")
+	if interval != "day" && interval != "week" && interval != "month" {
+		return c.Status(400).JSON(fiber.Map{"error": "interval must be 'day', 'week' or 'month'"})
+	}
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	base, err := baseCurrency(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	converted := baseAmounts(ledgerID, userID, base)
+	if to != nil {
+		converted = converted.Where("date < ?", *to)
+	}
+	if err := missingRate(converted, base); err != nil {
+		return conversionError(c, err)
+	}
+
+	// суммы по периодам
+	periods := db.Table("(?) AS t", converted).
+		Select("date_trunc(?, date) AS period, "+
+			"COALESCE(SUM(CASE WHEN type = 'income' THEN base_amount END),0) AS income, "+
+			"COALESCE(SUM(CASE WHEN type = 'expense' THEN base_amount END),0) AS expense", interval).
+		Group("period")
+	// баланс до начала ряда
+	opening := db.Table("(?) AS t", converted).
+		Select("COALESCE(SUM(" + signedAmountSQL + "),0) AS amount")
+	if from != nil {
+		periods = periods.Where("date >= ?", *from)
+		opening = opening.Where("date < ?", *from)
+	} else {
+		opening = opening.Where("FALSE")
+	}
+
+	points := []balancePoint{}
+	if err := db.Table("(?) AS p", periods).Order("p.period").Scan(&points).Error; err != nil {
+		return conversionError(c, err)
+	}
+	var start struct{ Amount Money }
+	if err := opening.Scan(&start).Error; err != nil {
+		return conversionError(c, err)
+	}
+	// нарастающий итог считается здесь, чтобы переполнение суммы дало 422, а не ошибку драйвера
+	if err := runningBalance(start.Amount, points); err != nil {
+		return conversionError(c, err)
+	}
+	return c.JSON(points)
+}
+
+// runningBalance заполняет Balance точек нарастающим итогом от остатка opening
+func runningBalance(opening Money, points []balancePoint) error {
+	balance := opening
+	for i := range points {
+		var err error
+		if balance, err = balance.Add(points[i].Income); err != nil {
+			return err
+		}
+		if balance, err = balance.Sub(points[i].Expense); err != nil {
+			return err
+		}
+		points[i].Balance = balance
+	}
+	return nil
+}
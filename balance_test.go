@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParsePeriod(t *testing.T) {
+	date := func(year int, month time.Month, day int) *time.Time {
+		t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	tests := []struct {
+		query    string
+		from, to *time.Time
+		wantErr  bool
+	}{
+		{query: ""},
+		{query: "from=2026-03-01", from: date(2026, 3, 1)},
+		{query: "to=2026-03-31", to: date(2026, 4, 1)}, // дата без времени включает весь день
+		{query: "from=2026-03-01&to=2026-03-01", from: date(2026, 3, 1), to: date(2026, 3, 2)},
+		{query: "as_of=2026-02-28", to: date(2026, 3, 1)},
+		{query: "as_of=2026-02-28T23:59:59Z", to: func() *time.Time {
+			t := time.Date(2026, 2, 28, 23, 59, 59, 1, time.UTC)
+			return &t
+		}()},
+		{query: "from=2026-03-02&to=2026-03-01", wantErr: true},
+		{query: "from=2026-03-01T00:00:00Z&to=2026-02-28T23:59:59Z", wantErr: true},
+		{query: "as_of=2026-02-28&to=2026-03-01", wantErr: true},
+		{query: "as_of=2026-02-28&from=2026-01-01", wantErr: true},
+		{query: "from=March", wantErr: true},
+		{query: "as_of=2026-13-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		var from, to *time.Time
+		var err error
+		withQuery(t, tt.query, func(c *fiber.Ctx) { from, to, err = parsePeriod(c) })
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePeriod(%s) = %v, %v, want error", tt.query, from, to)
+			}
+			continue
+		}
+		if err != nil || !sameTime(from, tt.from) || !sameTime(to, tt.to) {
+			t.Errorf("parsePeriod(%s) = %v, %v, %v, want %v, %v", tt.query, from, to, err, tt.from, tt.to)
+		}
+	}
+}
+
+// sameTime сравнивает необязательные моменты времени
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func TestRunningBalance(t *testing.T) {
+	points := []balancePoint{
+		{Income: 100000, Expense: 25000},
+		{Income: 0, Expense: 100000},
+		{Income: 5050, Expense: 0},
+	}
+	if err := runningBalance(10000, points); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []Money{85000, -15000, -9950} {
+		if points[i].Balance != want {
+			t.Errorf("balance[%d] = %v, want %v", i, points[i].Balance, want)
+		}
+	}
+
+	overflow := []balancePoint{{Income: math.MaxInt64 - 1}, {Income: 2}}
+	if err := runningBalance(0, overflow); !errors.Is(err, errMoneyOverflow) {
+		t.Errorf("runningBalance with overflow = %v, want errMoneyOverflow", err)
+	}
+	if err := runningBalance(math.MinInt64+1, []balancePoint{{Expense: 2}}); !errors.Is(err, errMoneyOverflow) {
+		t.Errorf("runningBalance with underflow = %v, want errMoneyOverflow", err)
+	}
+}
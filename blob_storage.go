@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BlobStorage хранит содержимое файлов (вложений) по ключу; метаданные хранятся в бд
+type BlobStorage interface {
+	Put(key, contentType string, data []byte) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error // отсутствие файла не считается ошибкой
+}
+
+var errBlobNotFound = errors.New("blob not found")
+
+// blobStorage - хранилище вложений, настраивается в main через newBlobStorage
+var blobStorage BlobStorage = localBlobStorage{Dir: "attachments"}
+
+// newBlobStorage выбирает хранилище по переменным окружения: S3-совместимое, если задан
+// S3_BUCKET, иначе каталог ATTACHMENTS_DIR (по умолчанию ./attachments)
+func newBlobStorage() (BlobStorage, error) {
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		endpoint := os.Getenv("S3_ENDPOINT")
+		if endpoint == "" {
+			endpoint = "https://s3.amazonaws.com"
+		}
+		u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+		if err != nil || u.Host == "" {
+			return nil, errors.New("invalid S3_ENDPOINT " + endpoint)
+		}
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &s3BlobStorage{
+			Endpoint:  u,
+			Region:    region,
+			Bucket:    bucket,
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Client:    &http.Client{Timeout: time.Minute},
+		}, nil
+	}
+	dir := os.Getenv("ATTACHMENTS_DIR")
+	if dir == "" {
+		dir = "attachments"
+	}
+	return localBlobStorage{Dir: dir}, nil
+}
+
+// localBlobStorage хранит файлы в каталоге на диске сервера
+type localBlobStorage struct {
+	Dir string
+}
+
+func (s localBlobStorage) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
+
+func (s localBlobStorage) Put(key, contentType string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// запись во временный файл и переименование, чтобы не оставить половину файла при сбое
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s localBlobStorage) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+func (s localBlobStorage) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// s3BlobStorage хранит файлы в бакете S3 или совместимого хранилища (MinIO, Ceph, Yandex Object Storage).
+// Адреса в стиле path (endpoint/bucket/key), запросы подписываются AWS Signature Version 4
+type s3BlobStorage struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+func (s *s3BlobStorage) Put(key, contentType string, data []byte) error {
+	resp, err := s.do(http.MethodPut, key, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s3Error(resp)
+}
+
+func (s *s3BlobStorage) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errBlobNotFound
+	}
+	if err := s3Error(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3BlobStorage) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return s3Error(resp)
+}
+
+// s3Error превращает ответ с кодом не 2xx в ошибку с началом тела ответа
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return errors.New("s3: " + resp.Status + ": " + strings.TrimSpace(string(body)))
+}
+
+// do выполняет подписанный запрос к объекту бакета
+func (s *s3BlobStorage) do(method, key, contentType string, body []byte) (*http.Response, error) {
+	u := *s.Endpoint
+	base := strings.TrimRight(u.Path, "/")
+	u.Path = base + "/" + s.Bucket + "/" + key
+	u.RawPath = base + "/" + s3URIEncode(s.Bucket, false) + "/" + s3URIEncode(key, true) // кодирование по правилам S3
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, u.RawPath, body, time.Now().UTC())
+	return s.Client.Do(req)
+}
+
+// sign добавляет заголовки подписи AWS Signature Version 4. Подписываются host,
+// хеш тела и время запроса; path должен быть закодирован так же, как в адресе запроса
+func (s *s3BlobStorage) sign(req *http.Request, path string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"", // query-параметров нет
+		"host:" + req.URL.Host + "\n" + "x-amz-content-sha256:" + payloadHash + "\n" + "x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3URIEncode кодирует строку по правилам SigV4: без изменений остаются только
+// A-Z, a-z, 0-9, '-', '_', '.', '~' и, если keepSlash, '/'
+func s3URIEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || ch == '/' && keepSlash {
+			b.WriteByte(ch)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{ch})))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Budget - лимит расходов по категории (вместе с подкатегориями) на каждый период
+type Budget struct {
+	ID         uint      `gorm:"primaryKey"`
+	LedgerID   uint      `gorm:"not null;uniqueIndex:idx_budget_category"`
+	CategoryID uint      `gorm:"not null;uniqueIndex:idx_budget_category"`
+	Category   *Category `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Period     string    `gorm:"not null;default:month;uniqueIndex:idx_budget_category;check:budget_period_check,period IN ('week','month','year')"` //неделя, месяц или год
+	Limit      Money     `gorm:"column:limit_amount;not null" swaggertype:"string" example:"15000.00"`                                              //лимит в валюте Currency
+	Currency   string    `gorm:"size:3;not null" example:"RUB"`                                                                                    //валюта лимита, по умолчанию базовая валюта автора; в ней же считаются расходы
+	Rollover   bool      //неизрасходованный остаток (или перерасход) переносится на следующий период
+	StartDate  time.Time //начало первого периода, по умолчанию текущий период
+	CreatedAt  time.Time
+}
+
+// budgetStatus - исполнение бюджета за текущий период
+type budgetStatus struct {
+	BudgetID    uint      `json:"budget_id"`
+	CategoryID  uint      `json:"category_id"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"` // не включительно
+	Limit       Money     `json:"limit" swaggertype:"string"`
+	CarriedOver Money     `json:"carried_over" swaggertype:"string"` // перенос с прошлых периодов, если включен Rollover
+	Spent       Money     `json:"spent" swaggertype:"string"`
+	Remaining   Money     `json:"remaining" swaggertype:"string"`
+	PercentUsed float64   `json:"percent_used"`
+	Status      string    `json:"status" enums:"ok,warning,over"` // warning - израсходовано 80% и больше
+	Currency    string    `json:"currency"`
+}
+
+// periodStart возвращает начало периода, в который попадает t
+func periodStart(t time.Time, period string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case "week": // неделя начинается с понедельника
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// nextPeriod возвращает начало следующего периода
+func nextPeriod(start time.Time, period string) time.Time {
+	switch period {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "year":
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// periodsBetween возвращает число целых периодов от start до end, оба - начала периодов.
+// Считается без перебора, чтобы давняя дата начала не замедляла расчет
+func periodsBetween(start, end time.Time, period string) int64 {
+	switch period {
+	case "week": // дни считаются по календарным датам, переход на летнее время не влияет
+		days := (time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC).Unix() -
+			time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC).Unix()) / 86400
+		return days / 7
+	case "year":
+		return int64(end.Year() - start.Year())
+	}
+	return int64(end.Year()-start.Year())*12 + int64(end.Month()-start.Month())
+}
+
+// validateBudget проверяет бюджет перед сохранением
+func validateBudget(budget *Budget) error {
+	if budget.Period == "" {
+		budget.Period = "month"
+	}
+	if budget.Period != "week" && budget.Period != "month" && budget.Period != "year" {
+		return errors.New("Period must be 'week', 'month' or 'year'")
+	}
+	if budget.Limit <= 0 {
+		return errors.New("Limit must be positive")
+	}
+	currency, err := normalizeCurrency(budget.Currency)
+	if err != nil {
+		return err
+	}
+	budget.Currency = currency
+
+	var category Category
+	if err := db.Where("id = ? AND ledger_id = ?", budget.CategoryID, budget.LedgerID).First(&category).Error; err != nil {
+		return errors.New("Category not found")
+	}
+	if category.Type != "expense" {
+		return errors.New("Budgets can only be set for expense categories")
+	}
+
+	if budget.StartDate.IsZero() {
+		budget.StartDate = time.Now()
+	}
+	budget.StartDate = periodStart(budget.StartDate, budget.Period)
+
+	var count int64
+	err = db.Model(&Budget{}).
+		Where("ledger_id = ? AND category_id = ? AND period = ? AND id <> ?", budget.LedgerID, budget.CategoryID, budget.Period, budget.ID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("Budget for this category and period already exists")
+	}
+	return nil
+}
+
+// @Summary Get budgets
+// @Description List budgets of the authenticated user
+// @Tags budgets
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} Budget "List of budgets"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/budgets [get]
+func GetBudgets(c *fiber.Ctx) error {
+	var budgets []Budget
+	if err := db.Where("ledger_id = ?", c.Locals("ledger_id").(uint)).Order("id").Find(&budgets).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(budgets)
+}
+
+// @Summary Get a budget
+// @Description Get a budget by ID
+// @Tags budgets
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Budget ID"
+// @Success 200 {object} Budget "Budget"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Budget not found"
+// @Router /api/budgets/{id} [get]
+func GetBudget(c *fiber.Ctx) error {
+	var budget Budget
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&budget).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Budget not found"})
+	}
+	return c.JSON(budget)
+}
+
+// @Summary Create a budget
+// @Description Set a spending limit for an expense category per week, month or year. The limit is in the budget currency, by default the base currency of the author
+// @Tags budgets
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param budget body Budget true "Budget data"
+// @Success 201 {object} Budget "Created budget"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/budgets [post]
+func PostBudget(c *fiber.Ctx) error {
+	budget := new(Budget)
+	if err := c.BodyParser(budget); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	budget.ID = 0
+	budget.LedgerID = c.Locals("ledger_id").(uint)
+	budget.Category = nil
+	if budget.Currency == "" {
+		base, err := baseCurrency(c.Locals("user_id").(uint))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		budget.Currency = base
+	}
+
+	if err := validateBudget(budget); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Create(budget).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(budget)
+}
+
+// @Summary Update a budget
+// @Description Fully update a budget by ID
+// @Tags budgets
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Budget ID"
+// @Param budget body Budget true "Full budget data"
+// @Success 200 {object} Budget "Updated budget"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Budget not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/budgets/{id} [put]
+func PutBudget(c *fiber.Ctx) error {
+	var budget Budget
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&budget).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Budget not found"})
+	}
+
+	updated := new(Budget)
+	if err := c.BodyParser(updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	budget.CategoryID = updated.CategoryID
+	budget.Period = updated.Period
+	budget.Limit = updated.Limit
+	if updated.Currency != "" { //без валюты остается прежняя
+		budget.Currency = updated.Currency
+	}
+	budget.Rollover = updated.Rollover
+	budget.StartDate = updated.StartDate
+
+	if err := validateBudget(&budget); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Save(&budget).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(budget)
+}
+
+// @Summary Delete a budget
+// @Description Delete a budget by ID
+// @Tags budgets
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Budget ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Budget not found"
+// @Router /api/budgets/{id} [delete]
+func DeleteBudget(c *fiber.Ctx) error {
+	res := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).Delete(&Budget{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Budget not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Budget deleted successfully"})
+}
+
+// @Summary Get budget status
+// @Description Spent, remaining and percent used for every budget in the period containing the given date. Expenses of subcategories are included and converted into the budget currency by the exchange rates of the authenticated user
+// @Tags budgets
+// @Produce json
+// @Security ApiKeyAuth
+// @Param date query string false "Date inside the period (YYYY-MM-DD), defaults to today"
+// @Success 200 {array} budgetStatus "Budget status"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 422 {object} map[string]string "Missing exchange rate or amount out of range"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/budgets/status [get]
+func GetBudgetStatus(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	date := time.Now()
+	if v := c.Query("date"); v != "" {
+		var err error
+		if date, _, err = parseDateParam(v); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	var budgets []Budget
+	if err := db.Where("ledger_id = ? AND start_date <= ?", ledgerID, date).Order("id").Find(&budgets).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	statuses := []budgetStatus{}
+	for _, budget := range budgets {
+		status, err := calculateBudgetStatus(budget, userID, date)
+		if err != nil {
+			return conversionError(c, err)
+		}
+		statuses = append(statuses, status)
+	}
+	return c.JSON(statuses)
+}
+
+// calculateBudgetStatus считает расходы по бюджету за период, содержащий date,
+// в валюте бюджета по курсам пользователя userID
+func calculateBudgetStatus(budget Budget, userID uint, date time.Time) (budgetStatus, error) {
+	base := budget.Currency
+	start := periodStart(date.In(budget.StartDate.Location()), budget.Period)
+	status := budgetStatus{
+		BudgetID:    budget.ID,
+		CategoryID:  budget.CategoryID,
+		Period:      budget.Period,
+		PeriodStart: start,
+		PeriodEnd:   nextPeriod(start, budget.Period),
+		Limit:       budget.Limit,
+		Currency:    base,
+	}
+
+	// при переносе остатка учитываются расходы с начала бюджета
+	from := start
+	if budget.Rollover {
+		from = budget.StartDate
+	}
+	// расходы считаются по частям разбивки, чтобы чек делился между бюджетами категорий
+	expenses := splitLines(baseAmounts(budget.LedgerID, userID, base).
+		Where("type = 'expense' AND date >= ? AND date < ?", from, status.PeriodEnd))
+	expenses = db.Table("(?) AS l", expenses).Where("category_id IN ("+categoryTreeSQL+")", budget.CategoryID)
+	if err := missingRate(expenses, base); err != nil {
+		return status, err
+	}
+
+	var sums struct {
+		Spent       Money
+		SpentBefore Money
+	}
+	err := db.Table("(?) AS t", expenses).
+		Select("COALESCE(SUM(CASE WHEN date >= ? THEN base_amount END),0) AS spent, "+
+			"COALESCE(SUM(CASE WHEN date < ? THEN base_amount END),0) AS spent_before", start, start).
+		Scan(&sums).Error
+	if err != nil {
+		return status, err
+	}
+	status.Spent = sums.Spent
+
+	if budget.Rollover { // лимиты прошлых периодов минус потраченное в них
+		if periods := periodsBetween(budget.StartDate, start, budget.Period); periods > 0 {
+			if status.CarriedOver, err = budget.Limit.Mul(periods); err != nil {
+				return status, err
+			}
+		}
+		if status.CarriedOver, err = status.CarriedOver.Sub(sums.SpentBefore); err != nil {
+			return status, err
+		}
+	}
+
+	available, err := budget.Limit.Add(status.CarriedOver)
+	if err != nil {
+		return status, err
+	}
+	if status.Remaining, err = available.Sub(status.Spent); err != nil {
+		return status, err
+	}
+	switch {
+	case available > 0:
+		status.PercentUsed = math.Round(float64(status.Spent)*10000/float64(available)) / 100
+	case status.Spent > 0:
+		status.PercentUsed = 100
+	}
+	switch {
+	case status.Remaining < 0:
+		status.Status = "over"
+	case status.PercentUsed >= 80:
+		status.Status = "warning"
+	default:
+		status.Status = "ok"
+	}
+	return status, nil
+}
+
+// migrateBudgetCurrency добавляет бюджетам валюту лимита. Раньше лимит считался в базовой
+// валюте того, кто смотрит бюджет; существующим бюджетам назначается валюта владельца книги
+func migrateBudgetCurrency() error {
+	if !db.Migrator().HasTable(&Budget{}) {
+		return nil
+	}
+	dataType, err := columnType("budgets", "currency")
+	if err != nil || dataType != "" {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		steps := []string{
+			`ALTER TABLE budgets ADD COLUMN currency varchar(3)`,
+			`UPDATE budgets SET currency = COALESCE((SELECT u.base_currency FROM ledger_members m JOIN users u ON u.id = m.user_id
+				WHERE m.ledger_id = budgets.ledger_id AND m.role = 'owner' ORDER BY m.user_id LIMIT 1), 'RUB')`,
+			`ALTER TABLE budgets ALTER COLUMN currency SET NOT NULL`,
+		}
+		for _, step := range steps {
+			if err := tx.Exec(step).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Category - категория доходов или расходов книги, может быть вложенной
+type Category struct {
+	ID        uint      `gorm:"primaryKey"`
+	LedgerID  uint      `gorm:"not null;index"`
+	ParentID  *uint     //родительская категория, nil для категорий верхнего уровня
+	Parent    *Category `gorm:"constraint:OnDelete:SET NULL" json:"-"` //при удалении родителя подкатегории поднимаются на верхний уровень
+	Name      string    `gorm:"not null"`
+	Type      string    `gorm:"not null;check:category_type_check,type IN ('income','expense')"` //категория доходов или расходов
+	Color     string    //цвет в формате #RRGGBB
+	Icon      string    //название иконки на клиенте
+	CreatedAt time.Time
+}
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// categoryTreeSQL - id категории и всех ее подкатегорий
+const categoryTreeSQL = `WITH RECURSIVE tree AS (
+	SELECT id FROM categories WHERE id = ?
+	UNION ALL
+	SELECT c.id FROM categories c JOIN tree ON c.parent_id = tree.id
+) SELECT id FROM tree`
+
+// validateTransactionCategory проверяет, что категория относится к книге
+// и подходит по типу к транзакции
+func validateTransactionCategory(ledgerID uint, categoryID *uint, transactionType string) error {
+	if categoryID == nil {
+		return nil
+	}
+	var category Category
+	if err := db.Where("id = ? AND ledger_id = ?", *categoryID, ledgerID).First(&category).Error; err != nil {
+		return errors.New("Category not found")
+	}
+	if category.Type != transactionType {
+		return errors.New("Category type '" + category.Type + "' does not match transaction type")
+	}
+	return nil
+}
+
+// validateCategory проверяет поля категории перед сохранением
+func validateCategory(category *Category) error {
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		return errors.New("Name is required")
+	}
+	if category.Type != "income" && category.Type != "expense" {
+		return errors.New("Type must be 'income' or 'expense'")
+	}
+	if category.Color != "" && !colorPattern.MatchString(category.Color) {
+		return errors.New("Color must be in #RRGGBB format")
+	}
+
+	if category.ParentID != nil {
+		var parent Category
+		if err := db.Where("id = ? AND ledger_id = ?", *category.ParentID, category.LedgerID).First(&parent).Error; err != nil {
+			return errors.New("Parent category not found")
+		}
+		if parent.Type != category.Type {
+			return errors.New("Parent category must have the same type")
+		}
+		if category.ID != 0 { //при обновлении родитель не может быть самой категорией или ее потомком
+			var count int64
+			if err := db.Raw("SELECT COUNT(*) FROM ("+categoryTreeSQL+") t WHERE id = ?", category.ID, parent.ID).Scan(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errors.New("Category cannot be moved under itself")
+			}
+		}
+	}
+
+	// имена не повторяются среди соседних категорий без учета регистра
+	query := db.Model(&Category{}).
+		Where("ledger_id = ? AND type = ? AND LOWER(name) = LOWER(?) AND id <> ?", category.LedgerID, category.Type, category.Name, category.ID)
+	if category.ParentID != nil {
+		query = query.Where("parent_id = ?", *category.ParentID)
+	} else {
+		query = query.Where("parent_id IS NULL")
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("Category with this name already exists")
+	}
+	return nil
+}
+
+// @Summary Get categories
+// @Description List categories of the authenticated user. Subcategories reference their parent via ParentID
+// @Tags categories
+// @Produce json
+// @Security ApiKeyAuth
+// @Param type query string false "Category type" Enums(income, expense)
+// @Success 200 {array} Category "List of categories"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/categories [get]
+func GetCategories(c *fiber.Ctx) error {
+	var categories []Category
+	query := db.Where("ledger_id = ?", c.Locals("ledger_id").(uint))
+	if v := c.Query("type"); v != "" {
+		query = query.Where("type = ?", v)
+	}
+	if err := query.Order("name").Find(&categories).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(categories)
+}
+
+// @Summary Get a category
+// @Description Get a category by ID
+// @Tags categories
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Category ID"
+// @Success 200 {object} Category "Category"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Category not found"
+// @Router /api/categories/{id} [get]
+func GetCategory(c *fiber.Ctx) error {
+	var category Category
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&category).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+	}
+	return c.JSON(category)
+}
+
+// @Summary Create a category
+// @Description Create a category or a subcategory (with ParentID) for the authenticated user
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param category body Category true "Category data"
+// @Success 201 {object} Category "Created category"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/categories [post]
+func PostCategory(c *fiber.Ctx) error {
+	category := new(Category)
+	if err := c.BodyParser(category); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	category.ID = 0
+	category.LedgerID = c.Locals("ledger_id").(uint)
+	category.Parent = nil
+
+	if err := validateCategory(category); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Create(category).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(category)
+}
+
+// @Summary Update a category
+// @Description Fully update a category by ID. The type cannot be changed while the category has transactions or subcategories
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Category ID"
+// @Param category body Category true "Full category data"
+// @Success 200 {object} Category "Updated category"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Category not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/categories/{id} [put]
+func PutCategory(c *fiber.Ctx) error {
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	var category Category
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), ledgerID).First(&category).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+	}
+
+	updated := new(Category)
+	if err := c.BodyParser(updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if updated.Type != category.Type {
+		used, err := categoryInUse(category.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if used {
+			return c.Status(400).JSON(fiber.Map{"error": "Cannot change type of a category that is in use"})
+		}
+	}
+
+	// Обновляем все поля (кроме ID, LedgerID и CreatedAt)
+	category.ParentID = updated.ParentID
+	category.Name = updated.Name
+	category.Type = updated.Type
+	category.Color = updated.Color
+	category.Icon = updated.Icon
+
+	if err := validateCategory(&category); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Save(&category).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(category)
+}
+
+// categoryInUse сообщает, есть ли у категории транзакции (в том числе в корзине, их можно
+// восстановить), части разбивки или подкатегории
+func categoryInUse(categoryID uint) (bool, error) {
+	queries := []*gorm.DB{
+		db.Unscoped().Model(&Transaction{}).Where("category_id = ?", categoryID),
+		db.Model(&TransactionSplit{}).Where("category_id = ?", categoryID),
+		db.Model(&Category{}).Where("parent_id = ?", categoryID),
+	}
+	for _, query := range queries {
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// @Summary Delete a category
+// @Description Delete a category by ID. Its transactions become uncategorized and its subcategories move to the top level
+// @Tags categories
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Category ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Category not found"
+// @Router /api/categories/{id} [delete]
+func DeleteCategory(c *fiber.Ctx) error {
+	res := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).Delete(&Category{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Category not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Category deleted successfully"})
+}
+
+// migrateLegacyCategories переносит старые строковые категории транзакций в таблицу categories.
+// Строки, отличающиеся только регистром и пробелами, объединяются в одну категорию
+func migrateLegacyCategories() error {
+	dataType, err := columnType("transactions", "category")
+	if err != nil || dataType == "" {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		steps := []string{
+			`INSERT INTO categories (ledger_id, name, type, created_at)
+			SELECT DISTINCT ON (ledger_id, LOWER(TRIM(category)), type) ledger_id, TRIM(category), type, NOW()
+			FROM transactions
+			WHERE category IS NOT NULL AND TRIM(category) <> ''
+			AND NOT EXISTS (SELECT 1 FROM categories c WHERE c.ledger_id = transactions.ledger_id AND c.type = transactions.type
+				AND c.parent_id IS NULL AND LOWER(c.name) = LOWER(TRIM(transactions.category)))
+			ORDER BY ledger_id, LOWER(TRIM(category)), type, TRIM(category)`,
+			`UPDATE transactions SET category_id = c.id FROM categories c
+			WHERE c.ledger_id = transactions.ledger_id AND c.type = transactions.type
+			AND c.parent_id IS NULL AND LOWER(c.name) = LOWER(TRIM(transactions.category))
+			AND transactions.category_id IS NULL`,
+			`ALTER TABLE transactions DROP COLUMN category`,
+		}
+		for _, step := range steps {
+			if err := tx.Exec(step).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
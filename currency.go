@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExchangeRate - курс обмена, действующий с указанной даты до следующей записи
+type ExchangeRate struct {
+	ID           uint      `gorm:"primaryKey"`
+	UserID       uint      `gorm:"not null;uniqueIndex:idx_exchange_rate"`
+	FromCurrency string    `gorm:"size:3;not null;uniqueIndex:idx_exchange_rate"` // ISO 4217
+	ToCurrency   string    `gorm:"size:3;not null;uniqueIndex:idx_exchange_rate"`
+	Rate         float64   `gorm:"not null"` // сколько единиц ToCurrency за одну FromCurrency
+	Date         time.Time `gorm:"not null;uniqueIndex:idx_exchange_rate"`
+	CreatedAt    time.Time
+}
+
+// userProfile - настройки пользователя, доступные через API
+type userProfile struct {
+	ID           uint   `json:"id"`
+	Email        string `json:"email"`
+	BaseCurrency string `json:"base_currency"`
+}
+
+// rateImportResult - итог загрузки курсов из CSV
+type rateImportResult struct {
+	Imported int      `json:"imported"`
+	Errors   []string `json:"errors"` // ошибки по строкам, такие строки пропускаются
+}
+
+// normalizeCurrency приводит код валюты к виду ISO 4217 (три заглавные латинские буквы)
+func normalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", errors.New("currency must be a 3-letter ISO 4217 code")
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", errors.New("currency must be a 3-letter ISO 4217 code")
+		}
+	}
+	return code, nil
+}
+
+// baseCurrency возвращает базовую валюту пользователя
+func baseCurrency(userID uint) (string, error) {
+	var user User
+	if err := db.Select("base_currency").First(&user, userID).Error; err != nil {
+		return "", err
+	}
+	return user.BaseCurrency, nil
+}
+
+// baseAmounts - транзакции книги с суммой base_amount в базовой валюте пользователя,
+// округленной до копеек. Берется последний курс пользователя на дату транзакции, прямой или обратный.
+// Если курса нет, base_amount равен NULL
+func baseAmounts(ledgerID, userID uint, base string) *gorm.DB {
+	return db.Model(&Transaction{}).
+		Select(`transactions.*, ROUND(amount * CASE WHEN currency = ? THEN 1 ELSE COALESCE(
+			(SELECT r.rate::numeric FROM exchange_rates r
+				WHERE r.user_id = ? AND r.from_currency = transactions.currency AND r.to_currency = ? AND r.date <= transactions.date
+				ORDER BY r.date DESC LIMIT 1),
+			(SELECT 1 / r.rate::numeric FROM exchange_rates r
+				WHERE r.user_id = ? AND r.from_currency = ? AND r.to_currency = transactions.currency AND r.date <= transactions.date
+				ORDER BY r.date DESC LIMIT 1)
+		) END, 2) AS base_amount`, base, userID, base, userID, base).
+		Where("ledger_id = ?", ledgerID)
+}
+
+// convertToBase переводит сумму в базовую валюту по последнему курсу на дату,
+// так же как baseAmounts для транзакций
+func convertToBase(userID uint, amount Money, currency, base string, date time.Time) (Money, error) {
+	if currency == base {
+		return amount, nil
+	}
+	var result struct{ Amount *Money }
+	err := db.Raw(`SELECT ROUND(?::numeric * COALESCE(
+			(SELECT r.rate::numeric FROM exchange_rates r
+				WHERE r.user_id = ? AND r.from_currency = ? AND r.to_currency = ? AND r.date <= ?
+				ORDER BY r.date DESC LIMIT 1),
+			(SELECT 1 / r.rate::numeric FROM exchange_rates r
+				WHERE r.user_id = ? AND r.from_currency = ? AND r.to_currency = ? AND r.date <= ?
+				ORDER BY r.date DESC LIMIT 1)
+		), 2) AS amount`, amount, userID, currency, base, date, userID, base, currency, date).
+		Scan(&result).Error
+	if err != nil {
+		return 0, err
+	}
+	if result.Amount == nil {
+		return 0, &missingRateError{From: currency, To: base, Date: date}
+	}
+	return *result.Amount, nil
+}
+
+// missingRate проверяет, что для всех доходов и расходов выборки нашелся курс.
+// Переводы между счетами в баланс не входят и не проверяются
+func missingRate(converted *gorm.DB, base string) error {
+	var t Transaction
+	res := db.Table("(?) AS t", converted).Where("base_amount IS NULL AND type <> 'transfer'").Order("date").Limit(1).Scan(&t)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return &missingRateError{From: t.Currency, To: base, Date: t.Date}
+	}
+	return nil
+}
+
+type missingRateError struct {
+	From, To string
+	Date     time.Time
+}
+
+func (e *missingRateError) Error() string {
+	return "no exchange rate " + e.From + "->" + e.To + " on or before " + e.Date.Format("2006-01-02")
+}
+
+// conversionError отвечает 422 при отсутствии курса или переполнении суммы и 500 при прочих ошибках
+func conversionError(c *fiber.Ctx, err error) error {
+	var rateErr *missingRateError
+	if errors.As(err, &rateErr) {
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+	}
+	if errors.Is(err, errMoneyOverflow) { //без обертки драйвера, если сумма не поместилась при чтении из базы
+		return c.Status(422).JSON(fiber.Map{"error": errMoneyOverflow.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// @Summary Get profile
+// @Description Return the authenticated user's profile including the base currency used for reports
+// @Tags profile
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} userProfile "User profile"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /api/profile [get]
+func GetProfile(c *fiber.Ctx) error {
+	var user User
+	if err := db.First(&user, c.Locals("user_id").(uint)).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	return c.JSON(userProfile{ID: user.ID, Email: user.Email, BaseCurrency: user.BaseCurrency})
+}
+
+// @Summary Update profile
+// @Description Change the base currency the balance and reports are converted into
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param profile body userProfile true "Profile data, only base_currency is used"
+// @Success 200 {object} userProfile "Updated profile"
+// @Failure 400 {object} map[string]string "Invalid currency"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "User not found"
+// @Router /api/profile [put]
+func PutProfile(c *fiber.Ctx) error {
+	var req userProfile
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	currency, err := normalizeCurrency(req.BaseCurrency)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var user User
+	if err := db.First(&user, c.Locals("user_id").(uint)).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	user.BaseCurrency = currency
+	if err := db.Model(&user).Update("base_currency", currency).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(userProfile{ID: user.ID, Email: user.Email, BaseCurrency: user.BaseCurrency})
+}
+
+// @Summary Get exchange rates
+// @Description List exchange rates of the authenticated user, newest first
+// @Tags rates
+// @Produce json
+// @Security ApiKeyAuth
+// @Param from query string false "Source currency"
+// @Param to query string false "Target currency"
+// @Success 200 {array} ExchangeRate "List of exchange rates"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/rates [get]
+func GetRates(c *fiber.Ctx) error {
+	var rates []ExchangeRate
+	query := db.Where("user_id = ?", c.Locals("user_id").(uint))
+	if v := c.Query("from"); v != "" {
+		query = query.Where("from_currency = ?", strings.ToUpper(v))
+	}
+	if v := c.Query("to"); v != "" {
+		query = query.Where("to_currency = ?", strings.ToUpper(v))
+	}
+	if err := query.Order("date DESC").Find(&rates).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(rates)
+}
+
+// @Summary Set exchange rates
+// @Description Create or replace exchange rates. A rate for the same currency pair and date is overwritten
+// @Tags rates
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param rates body []ExchangeRate true "Exchange rates"
+// @Success 200 {array} ExchangeRate "Saved exchange rates"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/rates [post]
+func PostRates(c *fiber.Ctx) error {
+	var rates []ExchangeRate
+	if err := c.BodyParser(&rates); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	userID := c.Locals("user_id").(uint)
+	for i := range rates {
+		if err := prepareRate(&rates[i], userID); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "rate " + strconv.Itoa(i) + ": " + err.Error()})
+		}
+	}
+	if len(rates) > 0 {
+		saved, err := upsertRates(rates)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		rates = saved
+	}
+	return c.JSON(rates)
+}
+
+// @Summary Import exchange rates from CSV
+// @Description Load exchange rates from a CSV file with columns date,from,to,rate (date as YYYY-MM-DD). A header row is optional. Invalid rows are reported and skipped
+// @Tags rates
+// @Accept mpfd
+// @Produce json
+// @Security ApiKeyAuth
+// @Param file formData file true "CSV file"
+// @Success 200 {object} rateImportResult "Import result"
+// @Failure 400 {object} map[string]string "Missing or unreadable file"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/rates/import [post]
+func ImportRates(c *fiber.Ctx) error {
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "file is required"})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer file.Close()
+
+	userID := c.Locals("user_id").(uint)
+	result := rateImportResult{Errors: []string{}}
+	var rates []ExchangeRate
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		if line == 1 && strings.EqualFold(record[0], "date") { //заголовок
+			continue
+		}
+
+		rate := ExchangeRate{FromCurrency: record[1], ToCurrency: record[2]}
+		rate.Date, err = time.Parse("2006-01-02", record[0])
+		if err == nil {
+			rate.Rate, err = strconv.ParseFloat(record[3], 64)
+		}
+		if err == nil {
+			err = prepareRate(&rate, userID)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, "line "+strconv.Itoa(line)+": "+err.Error())
+			continue
+		}
+		rates = append(rates, rate)
+	}
+
+	if len(rates) > 0 {
+		saved, err := upsertRates(rates)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		result.Imported = len(saved)
+	}
+	return c.JSON(result)
+}
+
+// @Summary Delete an exchange rate
+// @Description Delete an exchange rate by ID
+// @Tags rates
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Exchange rate ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Exchange rate not found"
+// @Router /api/rates/{id} [delete]
+func DeleteRate(c *fiber.Ctx) error {
+	res := db.Where("id = ? AND user_id = ?", c.Params("id"), c.Locals("user_id").(uint)).Delete(&ExchangeRate{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Exchange rate not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Exchange rate deleted successfully"})
+}
+
+// prepareRate проверяет курс и привязывает его к пользователю
+func prepareRate(rate *ExchangeRate, userID uint) error {
+	var err error
+	if rate.FromCurrency, err = normalizeCurrency(rate.FromCurrency); err != nil {
+		return err
+	}
+	if rate.ToCurrency, err = normalizeCurrency(rate.ToCurrency); err != nil {
+		return err
+	}
+	if rate.FromCurrency == rate.ToCurrency {
+		return errors.New("from and to currencies must differ")
+	}
+	if rate.Rate <= 0 {
+		return errors.New("rate must be positive")
+	}
+	if rate.Date.IsZero() {
+		return errors.New("date is required")
+	}
+	rate.ID = 0
+	rate.UserID = userID
+	rate.Date = time.Date(rate.Date.Year(), rate.Date.Month(), rate.Date.Day(), 0, 0, 0, 0, rate.Date.Location()) //курс действует с начала дня
+	return nil
+}
+
+// upsertRates сохраняет курсы, перезаписывая курс на ту же дату.
+// Повторы внутри одной загрузки схлопываются, побеждает последний
+func upsertRates(rates []ExchangeRate) ([]ExchangeRate, error) {
+	type rateKey struct {
+		from, to string
+		date     time.Time
+	}
+	positions := make(map[rateKey]int)
+	unique := rates[:0:0]
+	for _, rate := range rates {
+		key := rateKey{rate.FromCurrency, rate.ToCurrency, rate.Date.UTC()}
+		if i, ok := positions[key]; ok {
+			unique[i] = rate
+			continue
+		}
+		positions[key] = len(unique)
+		unique = append(unique, rate)
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "from_currency"}, {Name: "to_currency"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate"}),
+	}).Create(&unique).Error
+	return unique, err
+}
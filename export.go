@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// колонки выгрузки; порядок и имена не меняются, чтобы не ломать таблицы пользователей
+var exportColumns = []string{"id", "date", "type", "amount", "currency", "category", "account", "to_account", "to_amount", "description", "created_at"}
+
+// exportRow - транзакция в виде строки выгрузки
+type exportRow struct {
+	ID          uint   `json:"id"`
+	Date        string `json:"date"`
+	Type        string `json:"type"`
+	Amount      string `json:"amount"`
+	Currency    string `json:"currency"`
+	Category    string `json:"category"`
+	Account     string `json:"account"`
+	ToAccount   string `json:"to_account"`
+	ToAmount    string `json:"to_amount"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (r *exportRow) values() []string {
+	return []string{strconv.FormatUint(uint64(r.ID), 10), r.Date, r.Type, r.Amount, r.Currency, r.Category, r.Account, r.ToAccount, r.ToAmount, r.Description, r.CreatedAt}
+}
+
+// exportWriter пишет строки выгрузки в поток в одном из форматов
+type exportWriter interface {
+	WriteRow(row *exportRow) error
+	Close() error
+}
+
+// exportFormat - формат выгрузки: MIME-тип, расширение файла и конструктор
+type exportFormat struct {
+	ContentType string
+	Extension   string
+	DateLayout  string
+	New         func(w io.Writer) (exportWriter, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":  {"text/csv; charset=utf-8", "csv", "2006-01-02 15:04:05", newCSVExport},
+	"json": {"application/json", "json", time.RFC3339, newJSONExport},
+	"xlsx": {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx", "2006-01-02 15:04:05", newXLSXExport},
+}
+
+// @Summary Export transactions
+// @Description Stream transactions of the authenticated user as CSV, JSON or XLSX. Accepts the same filters and sorting as the transaction list, without pagination
+// @Tags transactions
+// @Produce text/csv
+// @Produce json
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Security ApiKeyAuth
+// @Param format query string false "File format" Enums(csv, json, xlsx) default(csv)
+// @Param from query string false "Start date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param type query string false "Transaction type" Enums(income, expense, transfer)
+// @Param account_id query int false "Account ID, transfers from and to the account are included"
+// @Param category_id query int false "Category ID, subcategories are included"
+// @Param min_amount query number false "Minimum amount"
+// @Param max_amount query number false "Maximum amount"
+// @Param q query string false "Search in description"
+// @Param tags query string false "Comma-separated tag IDs"
+// @Param tag_mode query string false "Match transactions with any or all of the tags" Enums(any, all) default(any)
+// @Param sort query string false "Sort field" Enums(date, amount, created_at) default(date)
+// @Param order query string false "Sort direction" Enums(asc, desc) default(desc)
+// @Success 200 {file} file "Exported file"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/export [get]
+func ExportTransactions(c *fiber.Ctx) error {
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	format, ok := exportFormats[c.Query("format", "csv")]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be one of: csv, json, xlsx"})
+	}
+	query, err := parseTransactionQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// названия категорий и счетов подставляются вместо id
+	categories, accounts := make(map[uint]string), make(map[uint]string)
+	var categoryList []Category
+	var accountList []Account
+	if err := db.Where("ledger_id = ?", ledgerID).Find(&categoryList).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Where("ledger_id = ?", ledgerID).Find(&accountList).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, category := range categoryList {
+		categories[category.ID] = category.Name
+	}
+	for _, account := range accountList {
+		accounts[account.ID] = account.Name
+	}
+
+	// курсор открывается до отправки заголовков, чтобы ошибку запроса можно было вернуть статусом
+	rows, err := query.Order(query.Filter(db.Model(&Transaction{}).Where("ledger_id = ?", ledgerID))).Rows()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, format.ContentType)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="transactions-`+time.Now().Format("2006-01-02")+"."+format.Extension+`"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer rows.Close()
+		if err := streamExport(w, rows, format, categories, accounts); err != nil {
+			log.Println("Ошибка выгрузки транзакций:", err) //заголовки уже отправлены, сообщить клиенту нельзя
+		}
+	})
+	return nil
+}
+
+// streamExport читает транзакции построчно и сразу пишет их в ответ
+func streamExport(w *bufio.Writer, rows *sql.Rows, format exportFormat, categories, accounts map[uint]string) error {
+	out, err := format.New(w)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var t Transaction
+		if err := db.ScanRows(rows, &t); err != nil {
+			return err
+		}
+		row := exportRow{
+			ID:        t.ID,
+			Date:      t.Date.Format(format.DateLayout),
+			Type:      t.Type,
+			Amount:    t.Amount.String(),
+			Currency:  t.Currency,
+			CreatedAt: t.CreatedAt.Format(format.DateLayout),
+		}
+		if t.CategoryID != nil {
+			row.Category = categories[*t.CategoryID]
+		}
+		if t.AccountID != nil {
+			row.Account = accounts[*t.AccountID]
+		}
+		if t.ToAccountID != nil {
+			row.ToAccount = accounts[*t.ToAccountID]
+		}
+		if t.ToAmount != nil {
+			row.ToAmount = t.ToAmount.String()
+		}
+		if t.Description != nil {
+			row.Description = *t.Description
+		}
+		if err := out.WriteRow(&row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// csvExport - выгрузка в CSV с заголовком
+type csvExport struct {
+	w *csv.Writer
+}
+
+func newCSVExport(w io.Writer) (exportWriter, error) {
+	out := &csvExport{w: csv.NewWriter(w)}
+	return out, out.w.Write(exportColumns)
+}
+
+func (e *csvExport) WriteRow(row *exportRow) error {
+	values := row.values()
+	for _, i := range exportTextColumns {
+		values[i] = csvSafe(values[i])
+	}
+	return e.w.Write(values)
+}
+
+// номера колонок с текстом пользователя: category, account, to_account, description
+var exportTextColumns = []int{5, 6, 7, 9}
+
+// csvSafe экранирует значение, которое табличный редактор принял бы за формулу
+// (CSV injection): к нему добавляется апостроф, и ячейка открывается как текст
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (e *csvExport) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonExport - выгрузка JSON-массивом, элементы пишутся по одному
+type jsonExport struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func newJSONExport(w io.Writer) (exportWriter, error) {
+	_, err := io.WriteString(w, "[")
+	return &jsonExport{w: w, enc: json.NewEncoder(w)}, err
+}
+
+func (e *jsonExport) WriteRow(row *exportRow) error {
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	return e.enc.Encode(row)
+}
+
+func (e *jsonExport) Close() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// xlsxExport - минимальная книга Excel с одним листом. Архив пишется потоком,
+// лист формируется построчно, поэтому вся выгрузка в памяти не держится
+type xlsxExport struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+// служебные файлы книги, которые не зависят от данных
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Transactions" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func newXLSXExport(w io.Writer) (exportWriter, error) {
+	e := &xlsxExport{zip: zip.NewWriter(w)}
+	for _, part := range xlsxParts {
+		f, err := e.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if e.sheet, err = e.zip.Create("xl/worksheets/sheet1.xml"); err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(e.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	return e, e.writeCells(exportColumns, nil)
+}
+
+// writeCells пишет строку листа; колонки из numeric записываются числами,
+// остальные - строковыми ячейками (inlineStr), которые Excel не вычисляет как формулы
+func (e *xlsxExport) writeCells(values []string, numeric map[int]bool) error {
+	e.row++
+	buf := []byte(`<row r="` + strconv.Itoa(e.row) + `">`)
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		ref := string(rune('A'+i)) + strconv.Itoa(e.row)
+		if numeric[i] {
+			buf = append(buf, `<c r="`+ref+`"><v>`+value+`</v></c>`...)
+			continue
+		}
+		buf = append(buf, `<c r="`+ref+`" t="inlineStr"><is><t xml:space="preserve">`...)
+		escaped := new(xmlBuffer)
+		xml.EscapeText(escaped, []byte(value))
+		buf = append(buf, escaped.b...)
+		buf = append(buf, `</t></is></c>`...)
+	}
+	buf = append(buf, `</row>`...)
+	_, err := e.sheet.Write(buf)
+	return err
+}
+
+// номера числовых колонок: id, amount, to_amount
+var xlsxNumericColumns = map[int]bool{0: true, 3: true, 8: true}
+
+func (e *xlsxExport) WriteRow(row *exportRow) error {
+	return e.writeCells(row.values(), xlsxNumericColumns)
+}
+
+func (e *xlsxExport) Close() error {
+	if _, err := io.WriteString(e.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+// xmlBuffer - io.Writer для xml.EscapeText
+type xmlBuffer struct{ b []byte }
+
+func (x *xmlBuffer) Write(p []byte) (int, error) {
+	x.b = append(x.b, p...)
+	return len(p), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "Coffee", want: "Coffee"},
+		{in: "", want: ""},
+		{in: "=HYPERLINK(\"http://x\")", want: "'=HYPERLINK(\"http://x\")"},
+		{in: "+7 999", want: "'+7 999"},
+		{in: "-5", want: "'-5"},
+		{in: "@SUM(A1)", want: "'@SUM(A1)"},
+		{in: "\tcmd", want: "'\tcmd"},
+		{in: "\rcmd", want: "'\rcmd"},
+		{in: "a=b", want: "a=b"},
+	}
+	for _, tt := range tests {
+		if got := csvSafe(tt.in); got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCSVExportEscapesText(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newCSVExport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := exportRow{ID: 1, Date: "2026-03-01 10:00:00", Type: "expense", Amount: "-10.00", Currency: "USD",
+		Category: "=1+1", Account: "@cash", Description: "+cmd"}
+	if err := out.WriteRow(&row); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(exportColumns, ",") + "\n1,2026-03-01 10:00:00,expense,-10.00,USD,'=1+1,'@cash,,,'+cmd,\n"
+	if buf.String() != want {
+		t.Errorf("CSV export = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestXLSXExportWritesTextAsStrings(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newXLSXExport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.WriteRow(&exportRow{ID: 1, Amount: "10.00", Description: "=1+1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := r.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, _ := io.ReadAll(f)
+	for _, cell := range []string{
+		`<c r="A2"><v>1</v></c>`,
+		`<c r="D2"><v>10.00</v></c>`,
+		`<c r="J2" t="inlineStr"><is><t xml:space="preserve">=1+1</t></is></c>`,
+	} {
+		if !bytes.Contains(sheet, []byte(cell)) {
+			t.Errorf("sheet does not contain %s:\n%s", cell, sheet)
+		}
+	}
+}
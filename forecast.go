@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errForecastOutOfRange - проекция остатка не помещается в допустимый диапазон сумм,
+// обычно из-за регулярных правил с огромными суммами
+var errForecastOutOfRange = errors.New("Forecast balance is out of range, check the amounts of recurring rules")
+
+// forecastItem - известная будущая операция: повторение регулярного правила
+// или уже внесенная транзакция с будущей датой
+type forecastItem struct {
+	Date            time.Time `json:"date"`
+	Type            string    `json:"type"`
+	Amount          Money     `json:"amount" swaggertype:"string"` // в базовой валюте
+	Description     *string   `json:"description"`
+	RecurringRuleID *uint     `json:"recurring_rule_id,omitempty"`
+	TransactionID   *uint     `json:"transaction_id,omitempty"`
+}
+
+// forecastCategory - средний дневной нерегулярный расход по категории
+type forecastCategory struct {
+	CategoryID   *uint  `json:"category_id"` // null - без категории
+	Name         string `json:"name"`
+	Total        Money  `json:"total" swaggertype:"string"`         // расход за период истории
+	DailyAverage Money  `json:"daily_average" swaggertype:"string"` // ожидаемый расход в день
+}
+
+// forecastDay - прогноз на один день
+type forecastDay struct {
+	Date          time.Time `json:"date"`
+	Scheduled     Money     `json:"scheduled" swaggertype:"string"`     // известные операции за день, со знаком
+	Discretionary Money     `json:"discretionary" swaggertype:"string"` // ожидаемые нерегулярные расходы
+	Balance       Money     `json:"balance" swaggertype:"string"`       // баланс на конец дня
+}
+
+// forecastResult - прогноз баланса по дням
+type forecastResult struct {
+	Currency         string             `json:"currency"` // базовая валюта пользователя
+	CurrentBalance   Money              `json:"current_balance" swaggertype:"string"`
+	ProjectedBalance Money              `json:"projected_balance" swaggertype:"string"` // баланс на конец последнего дня
+	Threshold        Money              `json:"threshold" swaggertype:"string"`
+	LowBalanceDate   *time.Time         `json:"low_balance_date"` // первый день, когда баланс ниже порога; null - не опускается
+	MinBalance       Money              `json:"min_balance" swaggertype:"string"`
+	MinBalanceDate   time.Time          `json:"min_balance_date"`
+	HistoryDays      int                `json:"history_days"` // за сколько дней посчитаны средние расходы
+	Days             []forecastDay      `json:"days"`
+	Scheduled        []forecastItem     `json:"scheduled"`
+	Discretionary    []forecastCategory `json:"discretionary"`
+}
+
+// @Summary Get balance forecast
+// @Description Project the balance in the base currency day by day until the given date. Known entries are upcoming recurring occurrences and transactions dated in the future; overdue occurrences not yet created by the scheduler are summed into one entry per rule on the first forecast day; discretionary spending is the average daily expense per category over the history window, excluding transactions created by recurring rules
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param to query string false "Last forecast day (YYYY-MM-DD), defaults to the end of the current month"
+// @Param history_days query int false "Days of history for average spending (7-365)" default(90)
+// @Param threshold query number false "Low-balance threshold" default(0)
+// @Success 200 {object} forecastResult "Forecast"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 422 {object} map[string]string "Missing exchange rate or forecast balance out of range"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/forecast [get]
+func GetForecast(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := today.AddDate(0, 0, 1)
+	last := time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()) // последний день месяца
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, now.Location())
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "to must be a date in YYYY-MM-DD format"})
+		}
+		last = t
+	}
+	if last.Before(tomorrow) {
+		return c.Status(400).JSON(fiber.Map{"error": "to must be in the future"})
+	}
+	if last.After(today.AddDate(2, 0, 0)) {
+		return c.Status(400).JSON(fiber.Map{"error": "to must be within two years"})
+	}
+	end := last.AddDate(0, 0, 1)
+
+	historyDays, err := strconv.Atoi(c.Query("history_days", "90"))
+	if err != nil || historyDays < 7 || historyDays > 365 {
+		return c.Status(400).JSON(fiber.Map{"error": "history_days must be a number between 7 and 365"})
+	}
+	threshold, err := parseAmountParam(c, "threshold")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	base, err := baseCurrency(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	result := forecastResult{Currency: base, HistoryDays: historyDays}
+	if threshold != nil {
+		result.Threshold = *threshold
+	}
+
+	converted := baseAmounts(ledgerID, userID, base).Where("date < ?", end)
+	if err := missingRate(converted, base); err != nil {
+		return conversionError(c, err)
+	}
+
+	// текущий баланс - все операции до конца сегодняшнего дня
+	var current struct{ Amount Money }
+	err = db.Table("(?) AS t", converted).
+		Select("COALESCE(SUM("+signedAmountSQL+"),0) AS amount").
+		Where("date < ?", tomorrow).
+		Scan(&current).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	result.CurrentBalance = current.Amount
+
+	// транзакции, уже внесенные на будущие даты
+	var future []struct {
+		ID          uint
+		Date        time.Time
+		Type        string
+		BaseAmount  Money
+		Description *string
+	}
+	err = db.Table("(?) AS t", converted).
+		Select("id, date, type, base_amount, description").
+		Where("date >= ? AND type IN ('income','expense')", tomorrow).
+		Order("date, id").
+		Scan(&future).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	result.Scheduled = []forecastItem{}
+	for _, t := range future {
+		id := t.ID
+		result.Scheduled = append(result.Scheduled, forecastItem{Date: t.Date, Type: t.Type, Amount: t.BaseAmount, Description: t.Description, TransactionID: &id})
+	}
+
+	// повторения регулярных правил, которые еще не созданы. Просроченные повторения
+	// планировщик создаст в ближайший проход, поэтому они учитываются в первый день
+	// прогноза одной суммой на правило
+	var rules []RecurringRule
+	if err := db.Where("ledger_id = ?", ledgerID).Find(&rules).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for i := range rules {
+		rule := &rules[i]
+		overdue := rule.overdue(tomorrow)
+		dates := rule.occurrencesBetween(tomorrow, end)
+		if overdue == 0 && len(dates) == 0 {
+			continue
+		}
+		amount, err := convertToBase(userID, rule.Amount, rule.Currency, base, today)
+		if err != nil {
+			return conversionError(c, err)
+		}
+		if overdue > 0 {
+			total, err := amount.Mul(int64(overdue))
+			if err != nil {
+				return c.Status(422).JSON(fiber.Map{"error": errForecastOutOfRange.Error()})
+			}
+			result.Scheduled = append(result.Scheduled, forecastItem{Date: tomorrow, Type: rule.Type, Amount: total, Description: rule.Description, RecurringRuleID: &rule.ID})
+		}
+		for _, date := range dates {
+			result.Scheduled = append(result.Scheduled, forecastItem{Date: date, Type: rule.Type, Amount: amount, Description: rule.Description, RecurringRuleID: &rule.ID})
+		}
+	}
+	sort.SliceStable(result.Scheduled, func(i, j int) bool {
+		return result.Scheduled[i].Date.Before(result.Scheduled[j].Date)
+	})
+
+	// средние нерегулярные расходы; если история короче окна, делим на ее фактическую длину
+	historyFrom := today.AddDate(0, 0, -historyDays)
+	var first struct{ Date *time.Time }
+	err = db.Table("(?) AS t", converted).
+		Select("MIN(date) AS date").
+		Where("type = 'expense' AND recurring_rule_id IS NULL").
+		Scan(&first).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if first.Date != nil && first.Date.After(historyFrom) {
+		firstDay := time.Date(first.Date.Year(), first.Date.Month(), first.Date.Day(), 0, 0, 0, 0, now.Location())
+		result.HistoryDays = int(today.Sub(firstDay).Hours()/24 + 0.5)
+		if result.HistoryDays < 1 {
+			result.HistoryDays = 1
+		}
+		historyFrom = today.AddDate(0, 0, -result.HistoryDays)
+	}
+
+	result.Discretionary = []forecastCategory{}
+	err = db.Table("(?) AS t", splitLines(converted)).
+		Joins("LEFT JOIN categories ON categories.id = t.category_id").
+		Select("t.category_id, COALESCE(categories.name, '') AS name, SUM(t.base_amount) AS total, "+
+			"ROUND(SUM(t.base_amount) / ?, 2) AS daily_average", result.HistoryDays).
+		Where("t.type = 'expense' AND t.recurring_rule_id IS NULL AND t.date >= ? AND t.date < ?", historyFrom, today).
+		Group("t.category_id, categories.name").
+		Order("total DESC").
+		Scan(&result.Discretionary).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var discretionary Money
+	for _, category := range result.Discretionary {
+		if discretionary, err = discretionary.Add(category.DailyAverage); err != nil {
+			return c.Status(422).JSON(fiber.Map{"error": errForecastOutOfRange.Error()})
+		}
+	}
+
+	// проекция по дням
+	balance := result.CurrentBalance
+	result.MinBalance, result.MinBalanceDate = balance, today
+	next := 0
+	for day := tomorrow; day.Before(end); day = day.AddDate(0, 0, 1) {
+		point := forecastDay{Date: day, Discretionary: discretionary}
+		dayEnd := day.AddDate(0, 0, 1)
+		for ; next < len(result.Scheduled) && result.Scheduled[next].Date.Before(dayEnd); next++ {
+			item := result.Scheduled[next]
+			if item.Type == "income" {
+				point.Scheduled, err = point.Scheduled.Add(item.Amount)
+			} else {
+				point.Scheduled, err = point.Scheduled.Sub(item.Amount)
+			}
+			if err != nil {
+				return c.Status(422).JSON(fiber.Map{"error": errForecastOutOfRange.Error()})
+			}
+		}
+		if balance, err = balance.Add(point.Scheduled); err == nil {
+			balance, err = balance.Sub(discretionary)
+		}
+		if err != nil {
+			return c.Status(422).JSON(fiber.Map{"error": errForecastOutOfRange.Error()})
+		}
+		point.Balance = balance
+
+		if balance.Cmp(result.MinBalance) < 0 {
+			result.MinBalance, result.MinBalanceDate = balance, day
+		}
+		if result.LowBalanceDate == nil && balance.Cmp(result.Threshold) < 0 {
+			date := day
+			result.LowBalanceDate = &date
+		}
+		result.Days = append(result.Days, point)
+	}
+	result.ProjectedBalance = balance
+	return c.JSON(result)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// statementEntry - операция из банковской выписки до сохранения в бд
+type statementEntry struct {
+	Line        int // номер строки (записи) в файле для отчета об ошибках
+	Date        time.Time
+	Amount      Money // со знаком: отрицательная сумма - расход
+	Description string
+	Category    string // имя категории, сопоставляется с категориями пользователя
+	Currency    string // валюта из выписки, если формат ее передает
+	ExternalID  string // идентификатор операции в банке, если формат его передает
+	Err         error  // ошибка разбора, такая запись не импортируется
+}
+
+// statementParser разбирает файл выписки в записи для импорта. Ошибки отдельных
+// записей сохраняются в statementEntry.Err, ошибка возвращается, только если
+// файл нельзя прочитать целиком
+type statementParser interface {
+	Parse(r io.Reader) ([]statementEntry, error)
+}
+
+// statementParsers - поддерживаемые форматы выписок; параметры разбора читаются из формы
+var statementParsers = map[string]func(c *fiber.Ctx) (statementParser, error){
+	"csv": func(c *fiber.Ctx) (statementParser, error) {
+		return parseCSVMapping(c)
+	},
+	"ofx": func(c *fiber.Ctx) (statementParser, error) {
+		return ofxParser{}, nil
+	},
+	"qif": parseQIFOptions,
+	"camt053": func(c *fiber.Ctx) (statementParser, error) {
+		return camtParser{}, nil
+	},
+}
+
+// importOptions - общие параметры импорта для всех форматов
+type importOptions struct {
+	LedgerID  uint          // книга, в которую импортируются транзакции
+	UserID    uint          // автор транзакций
+	Actor     revisionActor // для истории изменений
+	AccountID *uint
+	Currency  string
+	DryRun    bool // только предпросмотр, ничего не сохраняется
+}
+
+// importRow - результат обработки одной записи выписки
+type importRow struct {
+	Line        int          `json:"line"`
+	Status      string       `json:"status" enums:"new,imported,duplicate,error"` // new - будет создана (dry_run)
+	Error       string       `json:"error,omitempty"`
+	Warning     string       `json:"warning,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+}
+
+// importResult - итог импорта выписки
+type importResult struct {
+	DryRun     bool        `json:"dry_run"`
+	Total      int         `json:"total"`
+	Imported   int         `json:"imported"` // в режиме dry_run - сколько записей было бы создано
+	Duplicates int         `json:"duplicates"`
+	Failed     int         `json:"failed"`
+	Rows       []importRow `json:"rows"`
+}
+
+// transactionHash - отпечаток операции для поиска дублей: дата, сумма, тип и описание
+func transactionHash(t *Transaction) string {
+	description := ""
+	if t.Description != nil {
+		description = strings.ToLower(strings.Join(strings.Fields(*t.Description), " "))
+	}
+	sum := sha256.Sum256([]byte(t.Date.In(time.Local).Format("2006-01-02") + "|" + t.Amount.String() + "|" + t.Type + "|" + description))
+	return hex.EncodeToString(sum[:])
+}
+
+// importFingerprints - отпечатки существующих транзакций для поиска дублей. Одинаковые
+// операции учитываются по количеству, чтобы две одинаковые покупки в один день не
+// схлопнулись. Транзакции с идентификатором банка и без него (внесенные вручную или
+// из CSV) считаются отдельно: запись с идентификатором уже сверена по нему и по
+// отпечатку сверяется только с транзакциями без идентификатора
+type importFingerprints struct {
+	external map[string]int
+	manual   map[string]int
+}
+
+func newImportFingerprints() importFingerprints {
+	return importFingerprints{external: make(map[string]int), manual: make(map[string]int)}
+}
+
+// add учитывает существующую транзакцию
+func (f importFingerprints) add(t *Transaction) {
+	if t.ExternalID != nil {
+		f.external[transactionHash(t)]++
+	} else {
+		f.manual[transactionHash(t)]++
+	}
+}
+
+// match ищет для записи выписки существующую транзакцию и, если нашлась, исключает
+// ее из дальнейшего поиска
+func (f importFingerprints) match(t *Transaction) bool {
+	hash := transactionHash(t)
+	if t.ExternalID == nil && f.external[hash] > 0 {
+		f.external[hash]--
+		return true
+	}
+	if f.manual[hash] > 0 {
+		f.manual[hash]--
+		return true
+	}
+	return false
+}
+
+// parseImportOptions читает общие параметры импорта из multipart-формы
+func parseImportOptions(c *fiber.Ctx) (importOptions, error) {
+	opts := importOptions{
+		LedgerID: c.Locals("ledger_id").(uint),
+		UserID:   c.Locals("user_id").(uint),
+		Actor:    requestActor(c),
+		Currency: c.FormValue("currency"),
+		DryRun:   c.FormValue("dry_run") == "true",
+	}
+	if v := c.FormValue("account_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return opts, fiber.NewError(400, "account_id must be a number")
+		}
+		accountID := uint(id)
+		opts.AccountID = &accountID
+	}
+
+	// счет и валюта проверяются так же, как при создании транзакции
+	sample := Transaction{AccountID: opts.AccountID, Currency: opts.Currency}
+	if err := transactionAccount(opts.LedgerID, &sample); err != nil {
+		return opts, fiber.NewError(400, err.Error())
+	}
+	if sample.Currency == "" {
+		base, err := baseCurrency(opts.UserID)
+		if err != nil {
+			return opts, err
+		}
+		sample.Currency = base
+	}
+	currency, err := normalizeCurrency(sample.Currency)
+	if err != nil {
+		return opts, fiber.NewError(400, err.Error())
+	}
+	opts.Currency = currency
+	return opts, nil
+}
+
+// importEntries превращает записи выписки в транзакции, отсеивает дубли уже
+// существующих транзакций книги и сохраняет новые (кроме режима dry_run)
+func importEntries(entries []statementEntry, opts importOptions) (*importResult, error) {
+	result := &importResult{DryRun: opts.DryRun, Total: len(entries), Rows: make([]importRow, len(entries))}
+
+	// категории книги по имени и типу
+	var categories []Category
+	if err := db.Where("ledger_id = ?", opts.LedgerID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	categoryIDs := make(map[string]uint)
+	for _, category := range categories {
+		categoryIDs[category.Type+"|"+strings.ToLower(category.Name)] = category.ID
+	}
+
+	var minDate, maxDate time.Time
+	for i, entry := range entries {
+		row := &result.Rows[i]
+		row.Line = entry.Line
+		if entry.Err == nil && entry.Amount == 0 {
+			entry.Err = errors.New("amount must not be zero")
+		}
+		if entry.Err == nil && entry.Currency != "" && !strings.EqualFold(entry.Currency, opts.Currency) {
+			entry.Err = errors.New("currency " + entry.Currency + " does not match import currency " + opts.Currency)
+		}
+		if entry.Err != nil {
+			row.Status = "error"
+			row.Error = entry.Err.Error()
+			result.Failed++
+			continue
+		}
+
+		transaction := &Transaction{
+			LedgerID:    opts.LedgerID,
+			CreatedByID: opts.UserID,
+			Type:        "income",
+			Amount:      entry.Amount,
+			Currency:    opts.Currency,
+			AccountID:   opts.AccountID,
+			Date:        entry.Date,
+		}
+		if entry.Amount < 0 {
+			transaction.Type = "expense"
+			transaction.Amount = -entry.Amount
+		}
+		if description := strings.TrimSpace(entry.Description); description != "" {
+			transaction.Description = &description
+		}
+		if externalID := strings.TrimSpace(entry.ExternalID); externalID != "" {
+			transaction.ExternalID = &externalID
+		}
+		if name := strings.TrimSpace(entry.Category); name != "" {
+			if id, ok := categoryIDs[transaction.Type+"|"+strings.ToLower(name)]; ok {
+				transaction.CategoryID = &id
+			} else {
+				row.Warning = "category '" + name + "' not found, transaction left uncategorized"
+			}
+		}
+		row.Transaction = transaction
+
+		if minDate.IsZero() || entry.Date.Before(minDate) {
+			minDate = entry.Date
+		}
+		if entry.Date.After(maxDate) {
+			maxDate = entry.Date
+		}
+	}
+
+	// идентификаторы банка, которые уже были импортированы; удаленные в корзину тоже
+	// считаются дублями, их можно восстановить
+	importedIDs := make(map[string]bool)
+	var externalIDs []string
+	for _, row := range result.Rows {
+		if row.Transaction != nil && row.Transaction.ExternalID != nil {
+			externalIDs = append(externalIDs, *row.Transaction.ExternalID)
+		}
+	}
+	if len(externalIDs) > 0 {
+		var ids []string
+		err := db.Unscoped().Model(&Transaction{}).Where("ledger_id = ? AND external_id IN ?", opts.LedgerID, externalIDs).
+			Pluck("external_id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			importedIDs[id] = true
+		}
+	}
+
+	// отпечатки уже существующих транзакций за период выписки, включая удаленные в
+	// корзину - по тому же правилу, что и идентификаторы банка выше
+	fingerprints := newImportFingerprints()
+	if !minDate.IsZero() {
+		var transactions []Transaction
+		err := db.Unscoped().Where("ledger_id = ? AND date >= ? AND date < ?", opts.LedgerID, minDate.AddDate(0, 0, -1), maxDate.AddDate(0, 0, 2)).
+			Find(&transactions).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range transactions {
+			fingerprints.add(&transactions[i])
+		}
+	}
+
+	var created []*Transaction
+	for i := range result.Rows {
+		row := &result.Rows[i]
+		if row.Transaction == nil {
+			continue
+		}
+		if id := row.Transaction.ExternalID; id != nil {
+			if importedIDs[*id] { // уже импортирована или повторяется в этом же файле
+				row.Status = "duplicate"
+				result.Duplicates++
+				continue
+			}
+			importedIDs[*id] = true
+		}
+		if fingerprints.match(row.Transaction) {
+			row.Status = "duplicate"
+			result.Duplicates++
+			continue
+		}
+		row.Status = "new"
+		created = append(created, row.Transaction)
+	}
+	result.Imported = len(created)
+
+	if opts.DryRun || len(created) == 0 {
+		return result, nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(created, 100).Error; err != nil {
+			return err
+		}
+		revisions := make([]*TransactionRevision, 0, len(created))
+		for _, transaction := range created {
+			revision, err := newRevision(opts.Actor, revisionCreate, nil, transaction)
+			if err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+		}
+		return tx.CreateInBatches(revisions, 100).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range result.Rows {
+		if result.Rows[i].Status == "new" {
+			result.Rows[i].Status = "imported"
+		}
+	}
+	return result, nil
+}
+
+// @Summary Import transactions from OFX, QIF or CAMT.053
+// @Description Import a bank statement in OFX (1.x SGML or 2.x XML), QIF or ISO 20022 CAMT.053 format. Credits become income and debits become expenses. The bank's transaction reference (OFX FITID, CAMT AcctSvcrRef) is stored, and entries that were already imported, including ones now in the trash, are reported as duplicates. Entries that cannot be parsed are reported per row, the rest of the file is still imported. With dry_run=true nothing is saved and the response is a preview
+// @Tags import
+// @Accept mpfd
+// @Produce json
+// @Security ApiKeyAuth
+// @Param format path string true "Statement format" Enums(ofx, qif, camt053)
+// @Param file formData file true "Statement file"
+// @Param date_order formData string false "QIF only: order of date parts" Enums(mdy, dmy, ymd) default(mdy)
+// @Param account_id formData int false "Account for imported transactions"
+// @Param currency formData string false "Currency, defaults to the account or base currency"
+// @Param dry_run formData bool false "Preview without saving" default(false)
+// @Success 200 {object} importResult "Import result"
+// @Failure 400 {object} map[string]string "Invalid file or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Unsupported format"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/import/{format} [post]
+func ImportStatement(c *fiber.Ctx) error {
+	return importStatement(c, c.Params("format"))
+}
+
+// importStatement - общий обработчик импорта: разбирает файл выбранным парсером и сохраняет записи
+func importStatement(c *fiber.Ctx, format string) error {
+	newParser, ok := statementParsers[format]
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "unsupported statement format '" + format + "'"})
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "file is required"})
+	}
+	parser, err := newParser(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	opts, err := parseImportOptions(c)
+	if err != nil {
+		return importError(c, err)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer file.Close()
+
+	entries, err := parser.Parse(file)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	result, err := importEntries(entries, opts)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(result)
+}
+
+// importError отвечает 400 на ошибки параметров и 500 на прочие
+func importError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{"error": fiberErr.Message})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
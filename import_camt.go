@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// camtParser разбирает выписки ISO 20022 CAMT.053 (BkToCstmrStmt). Каждая запись Ntry
+// становится одной операцией, детали TxDtls используются только для описания и ссылки
+type camtParser struct{}
+
+// camtDate - дата или дата со временем (BookgDt, ValDt)
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+// camtParty - контрагент; в новых версиях схемы имя вложено в Pty
+type camtParty struct {
+	Name      string `xml:"Nm"`
+	PartyName string `xml:"Pty>Nm"`
+}
+
+// camtEntry - запись Ntry выписки
+type camtEntry struct {
+	Amount struct {
+		Value    string `xml:",chardata"`
+		Currency string `xml:"Ccy,attr"`
+	} `xml:"Amt"`
+	Indicator string `xml:"CdtDbtInd"` // CRDT - зачисление, DBIT - списание
+	Reversal  bool   `xml:"RvslInd"`
+	Status    struct {
+		Value string `xml:",chardata"`
+		Code  string `xml:"Cd"` // camt.053.001.08 и новее
+	} `xml:"Sts"`
+	BookingDate camtDate `xml:"BookgDt"`
+	ValueDate   camtDate `xml:"ValDt"`
+	Reference   string   `xml:"AcctSvcrRef"`
+	EntryRef    string   `xml:"NtryRef"`
+	Info        string   `xml:"AddtlNtryInf"`
+	Details     []struct {
+		Reference   string    `xml:"Refs>AcctSvcrRef"`
+		EndToEndID  string    `xml:"Refs>EndToEndId"`
+		Creditor    camtParty `xml:"RltdPties>Cdtr"`
+		Debtor      camtParty `xml:"RltdPties>Dbtr"`
+		Remittance  []string  `xml:"RmtInf>Ustrd"`
+		Information string    `xml:"AddtlTxInf"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+// Parse разбирает XML-файл CAMT.053 в записи для импорта
+func (camtParser) Parse(r io.Reader) ([]statementEntry, error) {
+	decoder := xml.NewDecoder(r)
+	var entries []statementEntry
+	root := true
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("invalid XML: " + err.Error())
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root {
+			if start.Name.Local != "Document" {
+				return nil, errors.New("not a CAMT.053 file: root element must be Document")
+			}
+			root = false
+			continue
+		}
+		if start.Name.Local != "Ntry" {
+			continue
+		}
+
+		line, _ := decoder.InputPos()
+		var ntry camtEntry
+		if err := decoder.DecodeElement(&ntry, &start); err != nil {
+			return nil, errors.New("invalid XML: " + err.Error())
+		}
+		entries = append(entries, ntry.entry(line))
+	}
+	if root {
+		return nil, errors.New("not a CAMT.053 file: document is empty")
+	}
+	return entries, nil
+}
+
+// entry переводит запись Ntry в запись выписки
+func (n *camtEntry) entry(line int) statementEntry {
+	entry := statementEntry{Line: line, Currency: n.Amount.Currency, ExternalID: n.Reference}
+
+	var counterparty, remittance string
+	for _, details := range n.Details {
+		if entry.ExternalID == "" {
+			entry.ExternalID = details.Reference
+		}
+		if entry.ExternalID == "" && details.EndToEndID != "NOTPROVIDED" {
+			entry.ExternalID = details.EndToEndID
+		}
+		party := details.Debtor
+		if n.Indicator == "DBIT" { // при списании интересен получатель
+			party = details.Creditor
+		}
+		if counterparty == "" {
+			counterparty = firstNonEmpty(party.Name, party.PartyName)
+		}
+		if remittance == "" {
+			remittance = firstNonEmpty(strings.Join(details.Remittance, " "), details.Information)
+		}
+	}
+	if entry.ExternalID == "" {
+		entry.ExternalID = n.EntryRef
+	}
+	entry.Description = counterparty
+	if remittance = firstNonEmpty(remittance, n.Info); remittance != "" {
+		if entry.Description != "" {
+			entry.Description += " - "
+		}
+		entry.Description += remittance
+	}
+
+	if status := firstNonEmpty(n.Status.Code, strings.TrimSpace(n.Status.Value)); status != "" && status != "BOOK" {
+		entry.Err = errors.New("entry status " + status + " is not booked")
+		return entry
+	}
+
+	var err error
+	date := n.BookingDate
+	if date.Date == "" && date.DateTime == "" {
+		date = n.ValueDate
+	}
+	if entry.Date, err = parseCAMTDate(date); err != nil {
+		entry.Err = err
+		return entry
+	}
+	if entry.Amount, err = parseStatementAmount(n.Amount.Value, '.'); err != nil {
+		entry.Err = err
+		return entry
+	}
+	switch n.Indicator {
+	case "CRDT":
+	case "DBIT":
+		entry.Amount = -entry.Amount
+	default:
+		entry.Err = errors.New("invalid credit/debit indicator '" + n.Indicator + "'")
+		return entry
+	}
+	if n.Reversal { // сторно меняет направление операции
+		entry.Amount = -entry.Amount
+	}
+	return entry
+}
+
+// parseCAMTDate разбирает ISODate или ISODateTime (со смещением или без)
+func parseCAMTDate(d camtDate) (time.Time, error) {
+	if d.DateTime != "" {
+		if t, err := time.Parse(time.RFC3339, d.DateTime); err == nil {
+			return t, nil
+		}
+		if t, err := time.ParseInLocation("2006-01-02T15:04:05", d.DateTime, time.Local); err == nil {
+			return t, nil
+		}
+		return time.Time{}, errors.New("invalid date '" + d.DateTime + "'")
+	}
+	if d.Date == "" {
+		return time.Time{}, errors.New("booking date is missing")
+	}
+	t, err := time.ParseInLocation("2006-01-02", d.Date, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("invalid date '" + d.Date + "'")
+	}
+	return t, nil
+}
+
+// firstNonEmpty возвращает первую непустую строку
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
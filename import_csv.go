@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// csvMapping - соответствие колонок CSV-файла полям транзакции и формат значений
+type csvMapping struct {
+	Date             string // имя колонки из заголовка или ее номер с 1
+	Amount           string
+	Debit            string // списания, для sign=debit_credit
+	Credit           string // зачисления, для sign=debit_credit
+	Description      string
+	Category         string
+	Sign             string // negative_expense, positive_expense или debit_credit
+	DateLayout       string // layout для time.Parse
+	DecimalSeparator byte
+	Delimiter        rune
+	HasHeader        bool
+}
+
+// dateFormatTokens переводит привычную запись формата даты в layout Go
+var dateFormatTokens = strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02", "HH", "15", "mm", "04", "ss", "05")
+
+// parseCSVMapping читает настройки разбора CSV из multipart-формы
+func parseCSVMapping(c *fiber.Ctx) (*csvMapping, error) {
+	m := &csvMapping{
+		Date:        c.FormValue("date_column", "date"),
+		Amount:      c.FormValue("amount_column", "amount"),
+		Debit:       c.FormValue("debit_column"),
+		Credit:      c.FormValue("credit_column"),
+		Description: c.FormValue("description_column"),
+		Category:    c.FormValue("category_column"),
+		Sign:        c.FormValue("sign", "negative_expense"),
+		HasHeader:   c.FormValue("has_header", "true") != "false",
+	}
+
+	switch m.Sign {
+	case "negative_expense", "positive_expense":
+	case "debit_credit":
+		if m.Debit == "" || m.Credit == "" {
+			return nil, errors.New("debit_column and credit_column are required for sign=debit_credit")
+		}
+	default:
+		return nil, errors.New("sign must be one of: negative_expense, positive_expense, debit_credit")
+	}
+
+	m.DateLayout = c.FormValue("date_format", "YYYY-MM-DD")
+	if !strings.Contains(m.DateLayout, "2006") { // уже layout Go
+		m.DateLayout = dateFormatTokens.Replace(m.DateLayout)
+	}
+
+	switch c.FormValue("decimal_separator", ".") {
+	case ".":
+		m.DecimalSeparator = '.'
+	case ",":
+		m.DecimalSeparator = ','
+	default:
+		return nil, errors.New("decimal_separator must be '.' or ','")
+	}
+
+	switch delimiter := c.FormValue("delimiter", ","); delimiter {
+	case "tab", `\t`:
+		m.Delimiter = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == utf8.RuneError || r == '"' {
+			return nil, errors.New("delimiter must be a single character or 'tab'")
+		}
+		m.Delimiter = r
+	}
+	return m, nil
+}
+
+// columnIndex находит колонку по номеру (с 1) или по имени из заголовка
+func columnIndex(spec string, header map[string]int) (int, error) {
+	if spec == "" {
+		return -1, nil
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 1 {
+			return 0, errors.New("column numbers start from 1")
+		}
+		return n - 1, nil
+	}
+	if i, ok := header[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		return i, nil
+	}
+	return 0, errors.New("column '" + spec + "' not found in header")
+}
+
+// parseStatementAmount разбирает сумму из выписки: убирает разделители тысяч,
+// символы валют и понимает отрицательные суммы в скобках
+func parseStatementAmount(value string, decimalSeparator byte) (Money, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")")
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; {
+		case ch >= '0' && ch <= '9', ch == '-', ch == '+':
+			b.WriteByte(ch)
+		case ch == decimalSeparator:
+			b.WriteByte('.')
+		}
+	}
+	amount, err := ParseMoney(b.String())
+	if err != nil {
+		return 0, errors.New("invalid amount '" + value + "'")
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// Parse разбирает CSV-выписку в записи для импорта
+func (m *csvMapping) Parse(r io.Reader) ([]statementEntry, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = m.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header := make(map[string]int)
+	if m.HasHeader {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, errors.New("cannot read header: " + err.Error())
+		}
+		for i, name := range record {
+			name = strings.TrimPrefix(name, "\ufeff") // BOM в начале файлов из Excel
+			header[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+	}
+
+	columns := make(map[string]int)
+	specs := map[string]string{"date": m.Date, "description": m.Description, "category": m.Category}
+	if m.Sign == "debit_credit" {
+		specs["debit"], specs["credit"] = m.Debit, m.Credit
+	} else {
+		specs["amount"] = m.Amount
+	}
+	for field, spec := range specs {
+		i, err := columnIndex(spec, header)
+		if err != nil {
+			return nil, errors.New(field + ": " + err.Error())
+		}
+		columns[field] = i
+	}
+	cell := func(record []string, field string) string {
+		i := columns[field]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []statementEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			entries = append(entries, statementEntry{Line: parseErr.Line, Err: err})
+			continue
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" { // пустые строки пропускаем
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		entry := statementEntry{Line: line, Description: cell(record, "description"), Category: cell(record, "category")}
+		entry.Date, err = time.ParseInLocation(m.DateLayout, cell(record, "date"), time.Local)
+		if err != nil {
+			entry.Err = errors.New("invalid date '" + cell(record, "date") + "'")
+			entries = append(entries, entry)
+			continue
+		}
+		entry.Amount, entry.Err = csvEntryAmount(cell, record, m)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// csvEntryAmount возвращает сумму со знаком по выбранному соглашению о знаке
+func csvEntryAmount(cell func([]string, string) string, record []string, m *csvMapping) (Money, error) {
+	if m.Sign == "debit_credit" {
+		var total Money
+		if v := cell(record, "credit"); v != "" {
+			credit, err := parseStatementAmount(v, m.DecimalSeparator)
+			if err != nil {
+				return 0, err
+			}
+			if total, err = total.Add(credit); err != nil {
+				return 0, err
+			}
+		}
+		if v := cell(record, "debit"); v != "" {
+			debit, err := parseStatementAmount(v, m.DecimalSeparator)
+			if err != nil {
+				return 0, err
+			}
+			if debit < 0 { // некоторые банки пишут списания со знаком минус
+				debit = -debit
+			}
+			if total, err = total.Sub(debit); err != nil {
+				return 0, err
+			}
+		}
+		return total, nil
+	}
+
+	amount, err := parseStatementAmount(cell(record, "amount"), m.DecimalSeparator)
+	if err != nil {
+		return 0, err
+	}
+	if m.Sign == "positive_expense" {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// @Summary Import transactions from CSV
+// @Description Import a bank statement in CSV format. Columns are referenced by header name or by 1-based number. Rows matching an existing transaction by date, amount, type and description are reported as duplicates and skipped; transactions in the trash count as existing. With dry_run=true nothing is saved and the response is a preview
+// @Tags import
+// @Accept mpfd
+// @Produce json
+// @Security ApiKeyAuth
+// @Param file formData file true "CSV file"
+// @Param date_column formData string false "Date column" default(date)
+// @Param amount_column formData string false "Signed amount column" default(amount)
+// @Param debit_column formData string false "Debit (money out) column, for sign=debit_credit"
+// @Param credit_column formData string false "Credit (money in) column, for sign=debit_credit"
+// @Param description_column formData string false "Description column"
+// @Param category_column formData string false "Category name column, matched case-insensitively"
+// @Param sign formData string false "Sign convention" Enums(negative_expense, positive_expense, debit_credit) default(negative_expense)
+// @Param date_format formData string false "Date format, e.g. DD.MM.YYYY or a Go layout" default(YYYY-MM-DD)
+// @Param decimal_separator formData string false "Decimal separator: '.' (default) or ','"
+// @Param delimiter formData string false "Field delimiter: a single character or 'tab', defaults to ','"
+// @Param has_header formData bool false "First row is a header" default(true)
+// @Param account_id formData int false "Account for imported transactions"
+// @Param currency formData string false "Currency, defaults to the account or base currency"
+// @Param dry_run formData bool false "Preview without saving" default(false)
+// @Success 200 {object} importResult "Import result"
+// @Failure 400 {object} map[string]string "Invalid file or mapping"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/import/csv [post]
+func ImportCSV(c *fiber.Ctx) error {
+	return importStatement(c, "csv")
+}
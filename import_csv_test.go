@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatementAmount(t *testing.T) {
+	tests := []struct {
+		in        string
+		separator byte
+		want      Money
+		wantErr   bool
+	}{
+		{in: "1500.00", separator: '.', want: 150000},
+		{in: "-12.30", separator: '.', want: -1230},
+		{in: "$1,234.56", separator: '.', want: 123456},
+		{in: "1 234,56", separator: ',', want: 123456},
+		{in: "1.234,56 ₽", separator: ',', want: 123456},
+		{in: "(45.00)", separator: '.', want: -4500},
+		{in: "+7", separator: '.', want: 700},
+		{in: "abc", separator: '.', wantErr: true},
+		{in: "", separator: '.', wantErr: true},
+		{in: "1.234", separator: '.', wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatementAmount(tt.in, tt.separator)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseStatementAmount(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseStatementAmount(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCSVParse(t *testing.T) {
+	f, err := os.Open("testdata/statement.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	m := &csvMapping{
+		Date: "date", Amount: "Amount", Description: "description", Category: "4",
+		Sign: "negative_expense", DateLayout: "2006-01-02", DecimalSeparator: ',', Delimiter: ';', HasHeader: true,
+	}
+	entries, err := m.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		line        int
+		date        string
+		amount      Money
+		description string
+		category    string
+		err         string
+	}{
+		{line: 2, date: "2026-03-01", amount: -125050, description: "Supermarket", category: "Food"},
+		{line: 3, date: "2026-03-02", amount: 8500000, description: "Salary"},
+		{line: 5, date: "2026-03-05", amount: -30000, description: "Refund reversal", category: "Misc"},
+		{line: 6, err: "invalid date"},
+		{line: 7, date: "2026-03-07", err: "invalid amount"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Line != w.line {
+			t.Errorf("entry %d: line %d, want %d", i, e.Line, w.line)
+		}
+		if w.err != "" {
+			if e.Err == nil || !strings.Contains(e.Err.Error(), w.err) {
+				t.Errorf("entry %d: error %v, want %q", i, e.Err, w.err)
+			}
+			continue
+		}
+		if e.Err != nil {
+			t.Errorf("entry %d: unexpected error %v", i, e.Err)
+			continue
+		}
+		if got := e.Date.Format("2006-01-02"); got != w.date {
+			t.Errorf("entry %d: date %s, want %s", i, got, w.date)
+		}
+		if e.Amount != w.amount || e.Description != w.description || e.Category != w.category {
+			t.Errorf("entry %d: got %v %q %q, want %v %q %q", i, e.Amount, e.Description, e.Category, w.amount, w.description, w.category)
+		}
+	}
+}
+
+func TestCSVParseSignConventions(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping csvMapping
+		data    string
+		want    []Money
+	}{
+		{
+			name:    "positive expense",
+			mapping: csvMapping{Date: "1", Amount: "2", Sign: "positive_expense"},
+			data:    "2026-01-10,100.00\n2026-01-11,-20.00\n",
+			want:    []Money{-10000, 2000},
+		},
+		{
+			name:    "debit and credit columns",
+			mapping: csvMapping{Date: "date", Debit: "debit", Credit: "credit", Sign: "debit_credit", HasHeader: true},
+			data:    "date,debit,credit\n2026-01-10,100.00,\n2026-01-11,,55.50\n2026-01-12,-30.00,\n",
+			want:    []Money{-10000, 5550, -3000},
+		},
+	}
+	for _, tt := range tests {
+		m := tt.mapping
+		m.DateLayout, m.DecimalSeparator, m.Delimiter = "2006-01-02", '.', ','
+		entries, err := m.Parse(strings.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(entries) != len(tt.want) {
+			t.Fatalf("%s: got %d entries, want %d", tt.name, len(entries), len(tt.want))
+		}
+		for i, want := range tt.want {
+			if entries[i].Err != nil || entries[i].Amount != want {
+				t.Errorf("%s: entry %d = %v, %v, want %v", tt.name, i, entries[i].Amount, entries[i].Err, want)
+			}
+			if entries[i].Date.Location() != time.Local {
+				t.Errorf("%s: entry %d date is not in local time", tt.name, i)
+			}
+		}
+	}
+}
+
+func TestCSVParseUnknownColumn(t *testing.T) {
+	m := &csvMapping{Date: "posted", Amount: "amount", Sign: "negative_expense", DateLayout: "2006-01-02", DecimalSeparator: '.', Delimiter: ',', HasHeader: true}
+	if _, err := m.Parse(strings.NewReader("date,amount\n2026-01-10,1.00\n")); err == nil || !strings.Contains(err.Error(), "posted") {
+		t.Errorf("Parse with unknown column: %v, want column error", err)
+	}
+}
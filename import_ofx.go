@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ofxParser разбирает выписки OFX: версии 1.x (SGML, у элементов нет закрывающих
+// тегов) и 2.x (XML). Используются только операции STMTTRN банковских и карточных выписок
+type ofxParser struct{}
+
+// типы операций OFX, которые списывают деньги, даже если банк передал сумму без минуса
+var ofxDebitTypes = map[string]bool{
+	"DEBIT": true, "PAYMENT": true, "CHECK": true, "FEE": true, "SRVCHG": true,
+	"ATM": true, "POS": true, "CASH": true, "DIRECTDEBIT": true,
+}
+
+// Parse разбирает OFX-файл в записи для импорта
+func (ofxParser) Parse(r io.Reader) ([]statementEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	start := strings.Index(text, "<OFX>")
+	if start < 0 {
+		return nil, errors.New("not an OFX file: <OFX> element not found")
+	}
+
+	var entries []statementEntry
+	var fields map[string]string // поля текущей операции STMTTRN
+	currency, entryLine := "", 0
+	line, counted := 1, 0 // номер строки считается по мере продвижения по файлу
+	for pos := start; pos < len(text); {
+		open := strings.IndexByte(text[pos:], '<')
+		if open < 0 {
+			break
+		}
+		open += pos
+		end := strings.IndexByte(text[open:], '>')
+		if end < 0 {
+			break
+		}
+		end += open
+		next := strings.IndexByte(text[end+1:], '<')
+		if next < 0 {
+			next = len(text)
+		} else {
+			next += end + 1
+		}
+		line += strings.Count(text[counted:open], "\n")
+		counted = open
+
+		tag := strings.ToUpper(strings.TrimSpace(text[open+1 : end]))
+		value := strings.TrimSpace(html.UnescapeString(text[end+1 : next]))
+		pos = next
+
+		switch {
+		case tag == "STMTTRN":
+			fields, entryLine = make(map[string]string), line
+		case tag == "/STMTTRN":
+			if fields != nil {
+				entries = append(entries, ofxEntry(fields, entryLine, currency))
+				fields = nil
+			}
+		case tag == "CURDEF": // валюта выписки, указывается до списка операций
+			currency = value
+		case fields != nil && !strings.HasPrefix(tag, "/") && value != "":
+			fields[tag] = value
+		}
+	}
+	if fields != nil {
+		entries = append(entries, statementEntry{Line: entryLine, Err: errors.New("unexpected end of file inside STMTTRN")})
+	}
+	return entries, nil
+}
+
+// ofxEntry собирает запись выписки из полей STMTTRN
+func ofxEntry(fields map[string]string, line int, currency string) statementEntry {
+	entry := statementEntry{Line: line, Currency: currency, ExternalID: fields["FITID"], Description: fields["NAME"]}
+	if memo := fields["MEMO"]; memo != "" && memo != entry.Description {
+		if entry.Description != "" {
+			entry.Description += " - "
+		}
+		entry.Description += memo
+	}
+
+	var err error
+	if entry.Date, err = parseOFXDate(fields["DTPOSTED"]); err != nil {
+		entry.Err = err
+		return entry
+	}
+	amount := strings.ReplaceAll(fields["TRNAMT"], ",", ".") // некоторые банки пишут дробную часть через запятую
+	if entry.Amount, err = parseStatementAmount(amount, '.'); err != nil {
+		entry.Err = err
+		return entry
+	}
+	if entry.Amount > 0 && ofxDebitTypes[strings.ToUpper(fields["TRNTYPE"])] {
+		entry.Amount = -entry.Amount
+	}
+	return entry
+}
+
+// parseOFXDate разбирает дату OFX: YYYYMMDD[HHMMSS[.XXX]][смещение:зона], например 20240105120000.000[-5:EST]
+func parseOFXDate(value string) (time.Time, error) {
+	invalid := errors.New("invalid date '" + value + "'")
+	location := time.Local
+	if i := strings.IndexByte(value, '['); i >= 0 {
+		zone := strings.TrimSuffix(value[i+1:], "]")
+		value = value[:i]
+		offset, name, _ := strings.Cut(zone, ":")
+		hours, err := strconv.ParseFloat(offset, 64)
+		if err != nil {
+			return time.Time{}, invalid
+		}
+		location = time.FixedZone(name, int(hours*3600))
+	}
+	if i := strings.IndexByte(value, '.'); i >= 0 { // миллисекунды не нужны
+		value = value[:i]
+	}
+
+	var layout string
+	switch len(value) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, invalid
+	}
+	t, err := time.ParseInLocation(layout, value, location)
+	if err != nil {
+		return time.Time{}, invalid
+	}
+	return t, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// qifParser разбирает выписки QIF. Формат не хранит идентификаторов операций,
+// поэтому повторы отсеиваются только по дате, сумме и описанию
+type qifParser struct {
+	DateOrder string // порядок частей даты: mdy, dmy или ymd
+}
+
+// разделы QIF с операциями по счетам; инвестиционные и служебные разделы пропускаются
+var qifAccountSections = map[string]bool{
+	"": true, "type:bank": true, "type:cash": true, "type:ccard": true, "type:oth a": true, "type:oth l": true,
+}
+
+// parseQIFOptions читает настройки разбора QIF из multipart-формы
+func parseQIFOptions(c *fiber.Ctx) (statementParser, error) {
+	order := c.FormValue("date_order", "mdy")
+	switch order {
+	case "mdy", "dmy", "ymd":
+	default:
+		return nil, errors.New("date_order must be one of: mdy, dmy, ymd")
+	}
+	return qifParser{DateOrder: order}, nil
+}
+
+// Parse разбирает QIF-файл в записи для импорта
+func (p qifParser) Parse(r io.Reader) ([]statementEntry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var entries []statementEntry
+	fields := make(map[byte]string) // поля текущей записи по коду строки
+	section, start, line := "", 0, 0
+	investment := false
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRightFunc(scanner.Text(), unicode.IsSpace)
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff") // BOM
+		}
+		if text == "" {
+			continue
+		}
+
+		switch text[0] {
+		case '!': // заголовок раздела
+			section = strings.ToLower(strings.TrimSpace(text[1:]))
+			investment = investment || section == "type:invst"
+			fields = make(map[byte]string)
+		case '^': // конец записи
+			if len(fields) > 0 && qifAccountSections[section] {
+				entries = append(entries, p.entry(fields, start))
+			}
+			fields = make(map[byte]string)
+		case 'S', 'E', '$': // строки разбивки по категориям, учитывается только общая сумма
+		default:
+			if len(fields) == 0 {
+				start = line
+			}
+			if _, ok := fields[text[0]]; !ok {
+				fields[text[0]] = strings.TrimSpace(text[1:])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 && qifAccountSections[section] { // последняя запись без ^
+		entries = append(entries, p.entry(fields, start))
+	}
+	if len(entries) == 0 && investment {
+		return nil, errors.New("investment QIF files are not supported")
+	}
+	return entries, nil
+}
+
+// entry собирает запись выписки из полей QIF
+func (p qifParser) entry(fields map[byte]string, line int) statementEntry {
+	entry := statementEntry{Line: line, Description: fields['P']}
+	if memo := fields['M']; memo != "" && memo != entry.Description {
+		if entry.Description != "" {
+			entry.Description += " - "
+		}
+		entry.Description += memo
+	}
+
+	// категория вида "Родитель:Подкатегория/Класс"; [Счет] означает перевод и пропускается
+	if category := fields['L']; category != "" && !strings.HasPrefix(category, "[") {
+		category, _, _ = strings.Cut(category, "/")
+		entry.Category = category[strings.LastIndexByte(category, ':')+1:]
+	}
+
+	var err error
+	if entry.Date, err = parseQIFDate(fields['D'], p.DateOrder); err != nil {
+		entry.Err = err
+		return entry
+	}
+	amount, ok := fields['T']
+	if !ok {
+		amount = fields['U']
+	}
+	entry.Amount, entry.Err = parseStatementAmount(amount, '.')
+	return entry
+}
+
+// parseQIFDate разбирает дату QIF. Разделители бывают любые ("1/5'24", "05.01.2024"),
+// двузначный год после апострофа относится к 2000-м
+func parseQIFDate(value, order string) (time.Time, error) {
+	invalid := errors.New("invalid date '" + value + "'")
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	if len(parts) != 3 {
+		return time.Time{}, invalid
+	}
+	numbers := make(map[byte]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, invalid
+		}
+		numbers[order[i]] = n
+	}
+
+	year, month, day := numbers['y'], numbers['m'], numbers['d']
+	if year < 100 {
+		if year < 70 || strings.Contains(value, "'") {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+	if t.Month() != time.Month(month) || t.Day() != day { // 31.02 и подобные
+		return time.Time{}, invalid
+	}
+	return t, nil
+}
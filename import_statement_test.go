@@ -0,0 +1,187 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wantEntry - ожидаемая запись выписки; при непустом err остальные поля не проверяются
+type wantEntry struct {
+	line        int
+	date        time.Time
+	amount      Money
+	description string
+	category    string
+	currency    string
+	externalID  string
+	err         string
+}
+
+func localDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+func TestStatementParsers(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser statementParser
+		file   string
+		want   []wantEntry
+	}{
+		{
+			name:   "OFX",
+			parser: ofxParser{},
+			file:   "testdata/statement.ofx",
+			want: []wantEntry{
+				{line: 19, date: time.Date(2026, 3, 2, 12, 0, 0, 0, time.FixedZone("EST", -5*3600)), amount: -4210,
+					description: "Coffee & Co - Card 1234", currency: "USD", externalID: "2026030201"},
+				{line: 27, date: localDate(2026, 3, 5), amount: 250000, description: "ACME Payroll", currency: "USD", externalID: "2026030502"},
+				{line: 35, date: localDate(2026, 3, 6), amount: -500, description: "Monthly fee", currency: "USD", externalID: "2026030603"},
+				{line: 42, err: "invalid date"},
+			},
+		},
+		{
+			name:   "QIF",
+			parser: qifParser{DateOrder: "mdy"},
+			file:   "testdata/statement.qif",
+			want: []wantEntry{
+				{line: 2, date: localDate(2026, 3, 1), amount: -125050, description: "Supermarket - Weekly groceries", category: "Groceries"},
+				{line: 8, date: localDate(2026, 3, 5), amount: 8500000, description: "Salary"},
+				{line: 12, date: localDate(2026, 3, 7), amount: -30000, description: "Transfer to savings"},
+				{line: 19, err: "invalid date"},
+			},
+		},
+		{
+			name:   "CAMT.053",
+			parser: camtParser{},
+			file:   "testdata/statement_camt053.xml",
+			want: []wantEntry{
+				{line: 8, date: localDate(2026, 3, 2), amount: -12050, description: "Power Utility AG - Invoice 42", currency: "EUR", externalID: "REF-001"},
+				{line: 20, date: time.Date(2026, 3, 5, 9, 30, 0, 0, time.UTC), amount: 300000, description: "Employer GmbH", currency: "EUR", externalID: "E2E-777"},
+				{line: 30, date: localDate(2026, 3, 6), amount: -1500, description: "Reversed refund", currency: "EUR", externalID: "NTRY-3"},
+				{line: 39, err: "not booked"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			entries, err := tt.parser.Parse(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d: %+v", len(entries), len(tt.want), entries)
+			}
+			for i, w := range tt.want {
+				e := entries[i]
+				if e.Line != w.line {
+					t.Errorf("entry %d: line %d, want %d", i, e.Line, w.line)
+				}
+				if w.err != "" {
+					if e.Err == nil || !strings.Contains(e.Err.Error(), w.err) {
+						t.Errorf("entry %d: error %v, want %q", i, e.Err, w.err)
+					}
+					continue
+				}
+				if e.Err != nil {
+					t.Errorf("entry %d: unexpected error %v", i, e.Err)
+					continue
+				}
+				if !e.Date.Equal(w.date) {
+					t.Errorf("entry %d: date %v, want %v", i, e.Date, w.date)
+				}
+				if e.Amount != w.amount || e.Description != w.description || e.Category != w.category ||
+					e.Currency != w.currency || e.ExternalID != w.externalID {
+					t.Errorf("entry %d: got %v %q %q %q %q, want %v %q %q %q %q", i,
+						e.Amount, e.Description, e.Category, e.Currency, e.ExternalID,
+						w.amount, w.description, w.category, w.currency, w.externalID)
+				}
+			}
+		})
+	}
+}
+
+func TestStatementParsersRejectOtherFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser statementParser
+		data   string
+		err    string
+	}{
+		{name: "OFX without OFX element", parser: ofxParser{}, data: "date,amount\n", err: "not an OFX file"},
+		{name: "CAMT with another root", parser: camtParser{}, data: "<Statement/>", err: "root element must be Document"},
+		{name: "CAMT broken XML", parser: camtParser{}, data: "<Document><Ntry>", err: "invalid XML"},
+		{name: "QIF investment account", parser: qifParser{DateOrder: "mdy"}, data: "!Type:Invst\nD1/5'26\nT-10.00\n^\n", err: "investment QIF"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.parser.Parse(strings.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestParseOFXDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "20260105", want: localDate(2026, 1, 5)},
+		{in: "202601051230", want: time.Date(2026, 1, 5, 12, 30, 0, 0, time.Local)},
+		{in: "20260105123045.123", want: time.Date(2026, 1, 5, 12, 30, 45, 0, time.Local)},
+		{in: "20260105120000[-5:EST]", want: time.Date(2026, 1, 5, 17, 0, 0, 0, time.UTC)},
+		{in: "20260105120000[+3.5:IRT]", want: time.Date(2026, 1, 5, 8, 30, 0, 0, time.UTC)},
+		{in: "2026-01-05", wantErr: true},
+		{in: "20261305", wantErr: true},
+		{in: "20260105[x:EST]", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOFXDate(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseOFXDate(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseOFXDate(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseQIFDate(t *testing.T) {
+	tests := []struct {
+		in, order string
+		want      time.Time
+		wantErr   bool
+	}{
+		{in: "1/5'24", order: "mdy", want: localDate(2024, 1, 5)},
+		{in: "01/05/2024", order: "mdy", want: localDate(2024, 1, 5)},
+		{in: "05.01.2024", order: "dmy", want: localDate(2024, 1, 5)},
+		{in: "2024-01-05", order: "ymd", want: localDate(2024, 1, 5)},
+		{in: "1/5/99", order: "mdy", want: localDate(1999, 1, 5)},
+		{in: "1/5/24", order: "mdy", want: localDate(2024, 1, 5)},
+		{in: "2/31/2024", order: "mdy", wantErr: true},
+		{in: "1/5", order: "mdy", wantErr: true},
+		{in: "", order: "mdy", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseQIFDate(tt.in, tt.order)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseQIFDate(%q, %s) = %v, want error", tt.in, tt.order, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseQIFDate(%q, %s) = %v, %v, want %v", tt.in, tt.order, got, err, tt.want)
+		}
+	}
+}
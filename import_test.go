@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportFingerprints(t *testing.T) {
+	date := time.Date(2026, 3, 5, 12, 0, 0, 0, time.Local)
+	fitid := func(id string) *string { return &id }
+	coffee := "Coffee"
+
+	existing := []Transaction{
+		{Date: date, Type: "expense", Amount: 35000, Description: &coffee},                         // внесена вручную
+		{Date: date, Type: "expense", Amount: 35000, Description: &coffee, ExternalID: fitid("A")}, // из выписки банка
+	}
+	tests := []struct {
+		name string
+		rows []*string // идентификаторы банка одинаковых записей выписки
+		want []bool
+	}{
+		{name: "CSV rows match both transactions", rows: []*string{nil, nil, nil}, want: []bool{true, true, false}},
+		{name: "bank rows match only the manual transaction", rows: []*string{fitid("B"), fitid("C")}, want: []bool{true, false}},
+		{name: "CSV row keeps the manual transaction for a bank row", rows: []*string{nil, fitid("B"), fitid("C")}, want: []bool{true, true, false}},
+		{name: "bank row leaves the imported transaction for a CSV row", rows: []*string{fitid("B"), nil, nil}, want: []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		fingerprints := newImportFingerprints()
+		for i := range existing {
+			fingerprints.add(&existing[i])
+		}
+		for i, id := range tt.rows {
+			row := Transaction{Date: date, Type: "expense", Amount: 35000, Description: &coffee, ExternalID: id}
+			if got := fingerprints.match(&row); got != tt.want[i] {
+				t.Errorf("%s: row %d duplicate = %v, want %v", tt.name, i, got, tt.want[i])
+			}
+		}
+	}
+
+	// другая сумма или описание - другая операция; регистр и пробелы в описании не важны
+	fingerprints := newImportFingerprints()
+	fingerprints.add(&existing[0])
+	spaced := "  coffee "
+	if fingerprints.match(&Transaction{Date: date, Type: "expense", Amount: 35001, Description: &coffee}) {
+		t.Error("transaction with another amount matched")
+	}
+	if !fingerprints.match(&Transaction{Date: date, Type: "expense", Amount: 35000, Description: &spaced}) {
+		t.Error("transaction with the same description in other case did not match")
+	}
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// роли участников книги, каждая следующая включает права предыдущей
+const (
+	roleViewer = "viewer" // просмотр данных
+	roleEditor = "editor" // изменение транзакций, счетов, категорий, бюджетов и регулярных операций
+	roleOwner  = "owner"  // управление книгой, участниками и приглашениями
+)
+
+var ledgerRoleRank = map[string]int{roleViewer: 1, roleEditor: 2, roleOwner: 3}
+
+const ledgerInvitationTTL = 7 * 24 * time.Hour
+
+// Ledger - книга учета: транзакции, счета, категории, бюджеты и регулярные операции.
+// У каждого пользователя есть личная книга, общие книги создаются для совместного бюджета
+type Ledger struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"size:100;not null"`
+	Personal    bool   `gorm:"not null;default:false"` //личная книга создается при регистрации, ее нельзя удалить
+	CreatedByID uint   `gorm:"not null;index"`
+	CreatedAt   time.Time
+}
+
+// LedgerMember - участник книги с ролью
+type LedgerMember struct {
+	LedgerID  uint    `gorm:"primaryKey"`
+	Ledger    *Ledger `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID    uint    `gorm:"primaryKey;index"`
+	User      *User   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Role      string  `gorm:"size:10;not null;check:ledger_member_role_check,role IN ('owner','editor','viewer')"`
+	CreatedAt time.Time
+}
+
+// LedgerInvitation - приглашение в книгу по email. В бд хранится только хеш токена из письма
+type LedgerInvitation struct {
+	ID          uint    `gorm:"primaryKey"`
+	LedgerID    uint    `gorm:"not null;index"`
+	Ledger      *Ledger `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Email       string  `gorm:"size:255;not null"` //в нижнем регистре
+	Role        string  `gorm:"size:10;not null"`
+	TokenHash   string  `gorm:"size:64;not null;uniqueIndex"`
+	InvitedByID uint    `gorm:"not null"`
+	ExpiresAt   time.Time
+	AcceptedAt  *time.Time
+	CreatedAt   time.Time
+}
+
+// ledgerRequest - тело запросов создания и переименования книги
+type ledgerRequest struct {
+	Name string `json:"name"`
+}
+
+// ledgerResponse - книга с ролью текущего пользователя
+type ledgerResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Personal  bool      `json:"personal"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ledgerMemberResponse - участник книги
+type ledgerMemberResponse struct {
+	UserID   uint      `json:"user_id"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ledgerRoleRequest - тело запроса смены роли участника
+type ledgerRoleRequest struct {
+	Role string `json:"role"` // owner, editor или viewer
+}
+
+// ledgerInvitationRequest - тело запроса приглашения
+type ledgerInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"` // owner, editor или viewer
+}
+
+// ledgerInvitationResponse - ожидающее приглашение
+type ledgerInvitationResponse struct {
+	ID        uint      `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// acceptInvitationRequest - тело запроса принятия приглашения
+type acceptInvitationRequest struct {
+	Token string `json:"token"` // токен из письма
+}
+
+var (
+	errLastOwner     = errors.New("a ledger must keep at least one owner")
+	errPersonalOwner = errors.New("the owner of a personal ledger cannot be removed or demoted")
+)
+
+// createPersonalLedger создает личную книгу нового пользователя
+func createPersonalLedger(tx *gorm.DB, userID uint) error {
+	ledger := Ledger{Name: "Личный бюджет", Personal: true, CreatedByID: userID}
+	if err := tx.Create(&ledger).Error; err != nil {
+		return err
+	}
+	return tx.Create(&LedgerMember{LedgerID: ledger.ID, UserID: userID, Role: roleOwner}).Error
+}
+
+// LedgerAccess выбирает книгу запроса из заголовка X-Ledger-ID (по умолчанию личная книга)
+// и проверяет, что пользователь в ней состоит. Ставит ledger_id и ledger_role
+func LedgerAccess(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	query := db.Joins("JOIN ledgers ON ledgers.id = ledger_members.ledger_id").Where("ledger_members.user_id = ?", userID)
+	if header := c.Get("X-Ledger-ID"); header != "" {
+		ledgerID, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid X-Ledger-ID header"})
+		}
+		query = query.Where("ledger_members.ledger_id = ?", ledgerID)
+	} else {
+		query = query.Where("ledgers.personal AND ledgers.created_by_id = ?", userID)
+	}
+
+	var member LedgerMember
+	err := query.Take(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you are not a member of this ledger"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Locals("ledger_id", member.LedgerID)
+	c.Locals("ledger_role", member.Role)
+	return c.Next()
+}
+
+// RequireRole пропускает запрос, только если роль в книге запроса не ниже нужной
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if ledgerRoleRank[c.Locals("ledger_role").(string)] < ledgerRoleRank[role] {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "this action requires the " + role + " role in the ledger"})
+		}
+		return c.Next()
+	}
+}
+
+// ledgerMembership находит книгу из параметра :id вместе с ролью текущего пользователя.
+// Для книг, в которых пользователь не состоит, возвращает gorm.ErrRecordNotFound
+func ledgerMembership(c *fiber.Ctx) (*Ledger, *LedgerMember, error) {
+	var member LedgerMember
+	if err := db.Where("ledger_id = ? AND user_id = ?", c.Params("id"), c.Locals("user_id").(uint)).Take(&member).Error; err != nil {
+		return nil, nil, err
+	}
+	var ledger Ledger
+	if err := db.First(&ledger, member.LedgerID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &ledger, &member, nil
+}
+
+// ledgerOwner - ledgerMembership с проверкой роли владельца; при ошибке ответ уже отправлен
+func ledgerOwner(c *fiber.Ctx) (*Ledger, bool, error) {
+	ledger, member, err := ledgerMembership(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, c.Status(404).JSON(fiber.Map{"error": "Ledger not found"})
+	}
+	if err != nil {
+		return nil, false, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if member.Role != roleOwner {
+		return nil, false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "this action requires the owner role in the ledger"})
+	}
+	return ledger, true, nil
+}
+
+// validateLedgerRole проверяет название роли
+func validateLedgerRole(role string) error {
+	if _, ok := ledgerRoleRank[role]; !ok {
+		return errors.New("role must be 'owner', 'editor' or 'viewer'")
+	}
+	return nil
+}
+
+// checkOwnerChange не дает оставить книгу без владельца или лишить создателя личной книги прав.
+// Вызывается в транзакции перед сменой роли или удалением участника
+func checkOwnerChange(tx *gorm.DB, ledger *Ledger, member *LedgerMember, newRole string) error {
+	if member.Role != roleOwner || newRole == roleOwner {
+		return nil
+	}
+	if ledger.Personal && member.UserID == ledger.CreatedByID {
+		return errPersonalOwner
+	}
+	// блокировка владельцев не дает двум владельцам одновременно разжаловать друг друга
+	var owners []LedgerMember
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("ledger_id = ? AND role = ?", ledger.ID, roleOwner).Find(&owners).Error; err != nil {
+		return err
+	}
+	if len(owners) <= 1 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// @Summary Get ledgers
+// @Description Ledgers the user is a member of, with the user's role. Other endpoints work with the ledger from the X-Ledger-ID header, or with the personal ledger if the header is absent
+// @Tags ledgers
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} ledgerResponse "Ledgers"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers [get]
+func GetLedgers(c *fiber.Ctx) error {
+	ledgers := []ledgerResponse{}
+	err := db.Table("ledgers").
+		Select("ledgers.id, ledgers.name, ledgers.personal, ledger_members.role, ledgers.created_at").
+		Joins("JOIN ledger_members ON ledger_members.ledger_id = ledgers.id").
+		Where("ledger_members.user_id = ?", c.Locals("user_id").(uint)).
+		Order("ledgers.personal DESC, ledgers.id").
+		Scan(&ledgers).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(ledgers)
+}
+
+// @Summary Create a shared ledger
+// @Description Create a new ledger; the creator becomes its owner and can invite other users
+// @Tags ledgers
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param ledger body ledgerRequest true "Ledger name"
+// @Success 201 {object} ledgerResponse "Created ledger"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers [post]
+func PostLedger(c *fiber.Ctx) error {
+	var req ledgerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "name is required and must be at most 100 characters"})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	ledger := Ledger{Name: req.Name, CreatedByID: userID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ledger).Error; err != nil {
+			return err
+		}
+		return tx.Create(&LedgerMember{LedgerID: ledger.ID, UserID: userID, Role: roleOwner}).Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(ledgerResponse{ID: ledger.ID, Name: ledger.Name, Role: roleOwner, CreatedAt: ledger.CreatedAt})
+}
+
+// @Summary Rename a ledger
+// @Description Change the name of a ledger. Only owners can do this
+// @Tags ledgers
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Param ledger body ledgerRequest true "Ledger name"
+// @Success 200 {object} ledgerResponse "Updated ledger"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not an owner"
+// @Failure 404 {object} map[string]string "Ledger not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id} [put]
+func PutLedger(c *fiber.Ctx) error {
+	ledger, ok, err := ledgerOwner(c)
+	if !ok {
+		return err
+	}
+	var req ledgerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "name is required and must be at most 100 characters"})
+	}
+	ledger.Name = req.Name
+	if err := db.Model(ledger).Update("name", ledger.Name).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(ledgerResponse{ID: ledger.ID, Name: ledger.Name, Personal: ledger.Personal, Role: roleOwner, CreatedAt: ledger.CreatedAt})
+}
+
+// @Summary Delete a ledger
+// @Description Delete a shared ledger together with all its transactions and their change history, attachments, accounts, categories, tags, budgets and recurring rules. Only owners can do this; personal ledgers cannot be deleted
+// @Tags ledgers
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 400 {object} map[string]string "Personal ledger"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not an owner"
+// @Failure 404 {object} map[string]string "Ledger not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id} [delete]
+func DeleteLedger(c *fiber.Ctx) error {
+	ledger, ok, err := ledgerOwner(c)
+	if !ok {
+		return err
+	}
+	if ledger.Personal {
+		return c.Status(400).JSON(fiber.Map{"error": "a personal ledger cannot be deleted"})
+	}
+	var keys []string // файлы вложений удаляются после того, как удалены записи
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Attachment{}).
+			Where("transaction_id IN (SELECT id FROM transactions WHERE ledger_id = ?)", ledger.ID).
+			Pluck("storage_key", &keys).Error
+		if err != nil {
+			return err
+		}
+		// порядок важен: транзакции ссылаются на счета и правила, бюджеты - на категории.
+		// История изменений удаляется вместе с книгой: без книги ее некому посмотреть
+		for _, model := range []interface{}{&TransactionRevision{}, &Transaction{}, &Tag{}, &RecurringRule{}, &Budget{}, &Account{}, &Category{}} {
+			if err := tx.Unscoped().Where("ledger_id = ?", ledger.ID).Delete(model).Error; err != nil { //транзакции удаляются вместе с корзиной
+				return err
+			}
+		}
+		return tx.Delete(ledger).Error //участники и приглашения удаляются каскадно
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	deleteBlobs(keys)
+	return c.JSON(fiber.Map{"message": "Ledger deleted successfully"})
+}
+
+// @Summary Get ledger members
+// @Description Members of a ledger with their roles
+// @Tags ledgers
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Success 200 {array} ledgerMemberResponse "Members"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Ledger not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id}/members [get]
+func GetLedgerMembers(c *fiber.Ctx) error {
+	ledger, _, err := ledgerMembership(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Ledger not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	members := []ledgerMemberResponse{}
+	err = db.Table("ledger_members").
+		Select("ledger_members.user_id, users.email, ledger_members.role, ledger_members.created_at AS joined_at").
+		Joins("JOIN users ON users.id = ledger_members.user_id").
+		Where("ledger_members.ledger_id = ?", ledger.ID).
+		Order("ledger_members.created_at").
+		Scan(&members).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(members)
+}
+
+// @Summary Change a member's role
+// @Description Set the role of a ledger member. Only owners can do this; the last owner cannot be demoted
+// @Tags ledgers
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Param user_id path int true "User ID of the member"
+// @Param role body ledgerRoleRequest true "New role"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 400 {object} map[string]string "Invalid role or last owner"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not an owner"
+// @Failure 404 {object} map[string]string "Ledger or member not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id}/members/{user_id} [put]
+func PutLedgerMember(c *fiber.Ctx) error {
+	ledger, ok, err := ledgerOwner(c)
+	if !ok {
+		return err
+	}
+	var req ledgerRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := validateLedgerRole(req.Role); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var member LedgerMember
+		if err := tx.Where("ledger_id = ? AND user_id = ?", ledger.ID, c.Params("user_id")).Take(&member).Error; err != nil {
+			return err
+		}
+		if err := checkOwnerChange(tx, ledger, &member, req.Role); err != nil {
+			return err
+		}
+		return tx.Model(&member).Update("role", req.Role).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Member not found"})
+	case errors.Is(err, errLastOwner), errors.Is(err, errPersonalOwner):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Role updated successfully"})
+}
+
+// @Summary Remove a member
+// @Description Remove a member from a ledger. Owners can remove anyone, other members can only leave themselves. The last owner cannot leave
+// @Tags ledgers
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Param user_id path int true "User ID of the member"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 400 {object} map[string]string "Last owner"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not an owner"
+// @Failure 404 {object} map[string]string "Ledger or member not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id}/members/{user_id} [delete]
+func DeleteLedgerMember(c *fiber.Ctx) error {
+	ledger, self, err := ledgerMembership(c)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Ledger not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if self.Role != roleOwner && c.Params("user_id") != strconv.FormatUint(uint64(self.UserID), 10) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "this action requires the owner role in the ledger"})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var member LedgerMember
+		if err := tx.Where("ledger_id = ? AND user_id = ?", ledger.ID, c.Params("user_id")).Take(&member).Error; err != nil {
+			return err
+		}
+		if err := checkOwnerChange(tx, ledger, &member, ""); err != nil {
+			return err
+		}
+		return tx.Where("ledger_id = ? AND user_id = ?", member.LedgerID, member.UserID).Delete(&LedgerMember{}).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": "Member not found"})
+	case errors.Is(err, errLastOwner), errors.Is(err, errPersonalOwner):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "Member removed successfully"})
+}
+
+// @Summary Get pending invitations
+// @Description Invitations to a ledger that have not been accepted and have not expired. Only owners can see them
+// @Tags ledgers
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Success 200 {array} ledgerInvitationResponse "Invitations"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not an owner"
+// @Failure 404 {object} map[string]string "Ledger not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id}/invitations [get]
+func GetLedgerInvitations(c *fiber.Ctx) error {
+	ledger, ok, err := ledgerOwner(c)
+	if !ok {
+		return err
+	}
+	var invitations []LedgerInvitation
+	err = db.Where("ledger_id = ? AND accepted_at IS NULL AND expires_at > ?", ledger.ID, time.Now()).Order("id").Find(&invitations).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	response := make([]ledgerInvitationResponse, 0, len(invitations))
+	for _, invitation := range invitations {
+		response = append(response, ledgerInvitationResponse{ID: invitation.ID, Email: invitation.Email, Role: invitation.Role, ExpiresAt: invitation.ExpiresAt, CreatedAt: invitation.CreatedAt})
+	}
+	return c.JSON(response)
+}
+
+// @Summary Invite a user to a ledger
+// @Description Send an invitation to an email address. The recipient accepts it at /api/invitations/accept after logging in with the same email, or after registering. Only owners can invite
+// @Tags ledgers
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Param invitation body ledgerInvitationRequest true "Email and role"
+// @Success 201 {object} ledgerInvitationResponse "Created invitation"
+// @Failure 400 {object} map[string]string "Invalid email or role, or already a member"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not an owner"
+// @Failure 404 {object} map[string]string "Ledger not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id}/invitations [post]
+func PostLedgerInvitation(c *fiber.Ctx) error {
+	ledger, ok, err := ledgerOwner(c)
+	if !ok {
+		return err
+	}
+	var req ledgerInvitationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := validateLedgerRole(req.Role); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var count int64
+	err = db.Model(&LedgerMember{}).Joins("JOIN users ON users.id = ledger_members.user_id").
+		Where("ledger_members.ledger_id = ? AND users.email = ?", ledger.ID, email).Count(&count).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if count > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "this user is already a member of the ledger"})
+	}
+
+	token := randomToken(32)
+	invitation := LedgerInvitation{
+		LedgerID:    ledger.ID,
+		Email:       email,
+		Role:        req.Role,
+		TokenHash:   hashToken(token),
+		InvitedByID: c.Locals("user_id").(uint),
+		ExpiresAt:   time.Now().Add(ledgerInvitationTTL),
+	}
+	if err := db.Create(&invitation).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	body := "Здравствуйте!\n\nВас пригласили в общую книгу «" + ledger.Name + "» в Finance Tracker.\n" +
+		"Чтобы присоединиться, войдите (или зарегистрируйтесь) с этим адресом и откройте ссылку:\n" +
+		appLink("/invitations/accept", token) + "\n\nКод приглашения: " + token +
+		"\n\nПриглашение действует 7 дней. Если вы не ждали его, просто удалите это письмо.\n"
+	if err := mailer.Send(email, "Приглашение в книгу «"+ledger.Name+"»", body); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(ledgerInvitationResponse{ID: invitation.ID, Email: invitation.Email, Role: invitation.Role, ExpiresAt: invitation.ExpiresAt, CreatedAt: invitation.CreatedAt})
+}
+
+// @Summary Cancel an invitation
+// @Description Cancel a pending invitation. Only owners can do this
+// @Tags ledgers
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Ledger ID"
+// @Param invitation_id path int true "Invitation ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not an owner"
+// @Failure 404 {object} map[string]string "Ledger or invitation not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/ledgers/{id}/invitations/{invitation_id} [delete]
+func DeleteLedgerInvitation(c *fiber.Ctx) error {
+	ledger, ok, err := ledgerOwner(c)
+	if !ok {
+		return err
+	}
+	res := db.Where("id = ? AND ledger_id = ? AND accepted_at IS NULL", c.Params("invitation_id"), ledger.ID).Delete(&LedgerInvitation{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Invitation not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Invitation cancelled successfully"})
+}
+
+// @Summary Accept an invitation
+// @Description Join a ledger using the token from the invitation email. The invitation must be addressed to the email of the current user
+// @Tags ledgers
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body acceptInvitationRequest true "Invitation token"
+// @Success 200 {object} ledgerResponse "Joined ledger"
+// @Failure 400 {object} map[string]string "Invalid or expired invitation"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/invitations/accept [post]
+func AcceptLedgerInvitation(c *fiber.Ctx) error {
+	var req acceptInvitationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	userID := c.Locals("user_id").(uint)
+
+	var response ledgerResponse
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		var invitation LedgerInvitation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND email = ? AND accepted_at IS NULL AND expires_at > ?", hashToken(req.Token), user.Email, time.Now()).
+			First(&invitation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		invitation.AcceptedAt = &now
+		if err := tx.Save(&invitation).Error; err != nil {
+			return err
+		}
+		// если пользователь уже в книге, роль не понижается
+		member := LedgerMember{LedgerID: invitation.LedgerID, UserID: userID, Role: invitation.Role}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("ledger_id = ? AND user_id = ?", invitation.LedgerID, userID).Take(&member).Error; err != nil {
+			return err
+		}
+		var ledger Ledger
+		if err := tx.First(&ledger, invitation.LedgerID).Error; err != nil {
+			return err
+		}
+		response = ledgerResponse{ID: ledger.ID, Name: ledger.Name, Personal: ledger.Personal, Role: member.Role, CreatedAt: ledger.CreatedAt}
+		return nil
+	})
+	if errors.Is(err, errTokenInvalid) {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired invitation"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(response)
+}
+
+// migrateLedgers переводит данные из схемы, где все принадлежало пользователю (user_id),
+// в книги: каждому пользователю создается личная книга, куда переносятся его данные.
+// У транзакций и регулярных операций user_id становится автором (created_by_id)
+func migrateLedgers() error {
+	dataType, err := columnType("transactions", "user_id")
+	if err != nil || dataType == "" {
+		return err
+	}
+	log.Println("Миграция: данные пользователей -> личные книги")
+	if err := db.AutoMigrate(&Ledger{}, &LedgerMember{}); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		steps := []string{
+			`INSERT INTO ledgers (name, personal, created_by_id, created_at)
+			SELECT 'Личный бюджет', true, id, NOW() FROM users
+			WHERE NOT EXISTS (SELECT 1 FROM ledgers l WHERE l.personal AND l.created_by_id = users.id)`,
+			`INSERT INTO ledger_members (ledger_id, user_id, role, created_at)
+			SELECT id, created_by_id, 'owner', NOW() FROM ledgers WHERE personal
+			ON CONFLICT DO NOTHING`,
+			`DROP INDEX IF EXISTS idx_transaction_external`,
+			`DROP INDEX IF EXISTS idx_recurring_rules_user_id`,
+		}
+		for _, table := range []string{"transactions", "recurring_rules", "accounts", "categories", "budgets"} {
+			dataType, err := columnType(table, "user_id")
+			if err != nil {
+				return err
+			}
+			if dataType == "" {
+				continue
+			}
+			steps = append(steps,
+				`ALTER TABLE `+table+` ADD COLUMN IF NOT EXISTS ledger_id bigint`,
+				`UPDATE `+table+` SET ledger_id = l.id FROM ledgers l WHERE l.personal AND l.created_by_id = `+table+`.user_id`,
+				`ALTER TABLE `+table+` ALTER COLUMN ledger_id SET NOT NULL`)
+			if table == "transactions" || table == "recurring_rules" {
+				steps = append(steps, `ALTER TABLE `+table+` RENAME COLUMN user_id TO created_by_id`)
+			} else {
+				steps = append(steps, `ALTER TABLE `+table+` DROP COLUMN user_id`) //индексы по user_id удаляются вместе с колонкой
+			}
+		}
+		for _, step := range steps {
+			if err := tx.Exec(step).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCheckOwnerChange(t *testing.T) {
+	personal := &Ledger{ID: 1, Personal: true, CreatedByID: 7}
+	shared := &Ledger{ID: 2, CreatedByID: 7}
+	tests := []struct {
+		name    string
+		ledger  *Ledger
+		member  LedgerMember
+		newRole string // пусто - удаление участника
+		want    error
+	}{
+		{name: "editor demoted", ledger: shared, member: LedgerMember{UserID: 8, Role: roleEditor}, newRole: roleViewer},
+		{name: "viewer removed", ledger: shared, member: LedgerMember{UserID: 8, Role: roleViewer}},
+		{name: "owner stays owner", ledger: personal, member: LedgerMember{UserID: 7, Role: roleOwner}, newRole: roleOwner},
+		{name: "personal owner demoted", ledger: personal, member: LedgerMember{UserID: 7, Role: roleOwner}, newRole: roleEditor, want: errPersonalOwner},
+		{name: "personal owner removed", ledger: personal, member: LedgerMember{UserID: 7, Role: roleOwner}, want: errPersonalOwner},
+	}
+	for _, tt := range tests {
+		// до проверки числа владельцев бд не нужна
+		if err := checkOwnerChange(nil, tt.ledger, &tt.member, tt.newRole); !errors.Is(err, tt.want) {
+			t.Errorf("%s: checkOwnerChange = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateLedgerRole(t *testing.T) {
+	for _, role := range []string{roleOwner, roleEditor, roleViewer} {
+		if err := validateLedgerRole(role); err != nil {
+			t.Errorf("validateLedgerRole(%s) = %v", role, err)
+		}
+	}
+	for _, role := range []string{"", "admin", "Owner"} {
+		if err := validateLedgerRole(role); err == nil {
+			t.Errorf("validateLedgerRole(%q): want error", role)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           int
+	}{
+		{role: roleViewer, required: roleViewer, want: 200},
+		{role: roleViewer, required: roleEditor, want: fiber.StatusForbidden},
+		{role: roleEditor, required: roleEditor, want: 200},
+		{role: roleEditor, required: roleOwner, want: fiber.StatusForbidden},
+		{role: roleOwner, required: roleEditor, want: 200},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Use(func(c *fiber.Ctx) error {
+			c.Locals("ledger_role", tt.role)
+			return c.Next()
+		})
+		app.Get("/", RequireRole(tt.required), func(c *fiber.Ctx) error { return c.SendString("ok") })
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s for %s: status %d, want %d", tt.role, tt.required, resp.StatusCode, tt.want)
+		}
+	}
+}
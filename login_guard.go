@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// защита входа от перебора: первые попытки без задержки, дальше ожидание растет
+// вдвое с каждой ошибкой, после лимита вход блокируется на время
+const (
+	loginWindow          = 15 * time.Minute // за какой период считаются ошибки
+	loginFreeAttempts    = 3                // ошибок подряд без задержки для одного email
+	loginLockoutAttempts = 10               // после стольких ошибок email блокируется
+	ipFreeAttempts       = 20               // то же для одного IP по всем email
+	ipLockoutAttempts    = 50
+	loginLockout         = 15 * time.Minute
+)
+
+// причины, которые считаются неудачной попыткой; остальные записи только для аудита
+var loginFailureReasons = []string{"unknown_email", "wrong_password", "invalid_code"}
+
+// LoginAttempt - запись о попытке входа для аудита и ограничения перебора
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey"`
+	Email     string    `gorm:"size:255;not null;index:idx_login_attempt_email,priority:1"` //введенный email в нижнем регистре
+	UserID    *uint     `gorm:"index"`                                                      //nil, если пользователь не найден
+	User      *User     `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	IP        string    `gorm:"size:45;not null;index:idx_login_attempt_ip,priority:1"`
+	UserAgent string    `gorm:"size:255"`
+	Stage     string    `gorm:"size:20;not null"` //password или mfa
+	Success   bool      `gorm:"not null"`         //вход завершен и выданы токены
+	Reason    string    `gorm:"size:30"`          //причина отказа: unknown_email, wrong_password, invalid_code, unverified, mfa_required, throttled
+	CreatedAt time.Time `gorm:"index:idx_login_attempt_email,priority:2;index:idx_login_attempt_ip,priority:2"`
+}
+
+// хеш для сравнения, когда пользователь не найден, чтобы время ответа не выдавало существование email
+var dummyPasswordHash = GeneratePassword("dummy password for timing")
+
+// recordLoginAttempt сохраняет попытку входа; ошибка записи не мешает ответу
+func recordLoginAttempt(c *fiber.Ctx, email string, userID *uint, stage string, success bool, reason string) {
+	userAgent := c.Get(fiber.HeaderUserAgent)
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	attempt := LoginAttempt{Email: email, UserID: userID, IP: c.IP(), UserAgent: userAgent, Stage: stage, Success: success, Reason: reason}
+	if err := db.Create(&attempt).Error; err != nil {
+		log.Println("Ошибка записи попытки входа:", err)
+	}
+}
+
+// backoff возвращает, до какого момента запрещены попытки после failures ошибок
+func backoff(failures, free, lockout int, last time.Time) time.Time {
+	if failures >= lockout {
+		return last.Add(loginLockout)
+	}
+	if failures <= free {
+		return time.Time{}
+	}
+	delay := time.Duration(math.Pow(2, float64(failures-free-1))) * time.Second
+	if delay > loginLockout {
+		delay = loginLockout
+	}
+	return last.Add(delay)
+}
+
+// loginRetryAfter возвращает, сколько ждать до следующей попытки входа для email и IP.
+// Для email считаются ошибки после последнего успешного входа, для IP - все ошибки за окно
+func loginRetryAfter(email, ip string) (time.Duration, error) {
+	now := time.Now()
+	var account, address struct {
+		Failures int
+		Last     *time.Time
+	}
+	err := db.Model(&LoginAttempt{}).
+		Select("COUNT(*) AS failures, MAX(created_at) AS last").
+		Where("email = ? AND reason IN ? AND created_at > ?", email, loginFailureReasons, now.Add(-loginWindow)).
+		Where("created_at > COALESCE((SELECT MAX(created_at) FROM login_attempts WHERE email = ? AND success), '-infinity')", email).
+		Scan(&account).Error
+	if err != nil {
+		return 0, err
+	}
+	err = db.Model(&LoginAttempt{}).
+		Select("COUNT(*) AS failures, MAX(created_at) AS last").
+		Where("ip = ? AND reason IN ? AND created_at > ?", ip, loginFailureReasons, now.Add(-loginWindow)).
+		Scan(&address).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var until time.Time
+	if account.Last != nil {
+		until = backoff(account.Failures, loginFreeAttempts, loginLockoutAttempts, *account.Last)
+	}
+	if address.Last != nil {
+		if t := backoff(address.Failures, ipFreeAttempts, ipLockoutAttempts, *address.Last); t.After(until) {
+			until = t
+		}
+	}
+	if wait := until.Sub(now); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// loginThrottled проверяет ограничение и, если попытка запрещена, отвечает 429
+func loginThrottled(c *fiber.Ctx, email, stage string) (bool, error) {
+	wait, err := loginRetryAfter(email, c.IP())
+	if err != nil {
+		return false, c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	if wait == 0 {
+		return false, nil
+	}
+	recordLoginAttempt(c, email, nil, stage, false, "throttled")
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return true, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"message": "too many login attempts, try again later"})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	last := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		failures int
+		want     time.Duration // 0 - попытка разрешена сразу
+	}{
+		{failures: 0, want: 0},
+		{failures: 3, want: 0}, // бесплатные попытки
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 6, want: 4 * time.Second},
+		{failures: 9, want: 32 * time.Second},
+		{failures: 10, want: loginLockout}, // блокировка
+		{failures: 25, want: loginLockout},
+	}
+	for _, tt := range tests {
+		got := backoff(tt.failures, loginFreeAttempts, loginLockoutAttempts, last)
+		if tt.want == 0 {
+			if !got.IsZero() {
+				t.Errorf("backoff(%d) = %v, want no delay", tt.failures, got)
+			}
+			continue
+		}
+		if want := last.Add(tt.want); !got.Equal(want) {
+			t.Errorf("backoff(%d) = +%v, want +%v", tt.failures, got.Sub(last), tt.want)
+		}
+	}
+
+	// задержка до блокировки не превышает срок блокировки
+	if got := backoff(ipLockoutAttempts-1, ipFreeAttempts, ipLockoutAttempts, last); got.Sub(last) != loginLockout {
+		t.Errorf("backoff before IP lockout = +%v, want +%v", got.Sub(last), loginLockout)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailer отправляет письма пользователям
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// mailer - почта приложения, настраивается в main через newMailer
+var mailer Mailer
+
+// errMailNotConfigured - не задан способ отправки писем. В письмах ссылки и токены для входа
+// в аккаунт, поэтому без явной настройки они не пишутся в лог
+var errMailNotConfigured = errors.New("SMTP_HOST is not set; for development set MAIL_DIR to save emails as files or MAIL_LOG=true to print them to the log")
+
+// newMailer выбирает способ отправки по переменным окружения: SMTP, если задан
+// SMTP_HOST, для разработки - файлы в каталоге MAIL_DIR или лог при MAIL_LOG=true
+func newMailer() (Mailer, error) {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return smtpMailer{
+			Addr:     net.JoinHostPort(host, port),
+			Host:     host,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}, nil
+	}
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		return logMailer{Dir: dir}, nil
+	}
+	if os.Getenv("MAIL_LOG") == "true" {
+		log.Println("Почта: письма выводятся в лог вместе со ссылками и токенами, MAIL_LOG только для разработки")
+		return logMailer{}, nil
+	}
+	return nil, errMailNotConfigured
+}
+
+// buildMessage собирает письмо в формате RFC 5322 с текстом в UTF-8
+func buildMessage(from, to, subject, body string) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 { //строки base64 не длиннее 76 символов
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return []byte(b.String())
+}
+
+// smtpMailer отправляет письма через SMTP-сервер; STARTTLS включается, если сервер его поддерживает
+type smtpMailer struct {
+	Addr     string // host:port
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m smtpMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, buildMessage(m.From, to, subject, body))
+}
+
+// logMailer не отправляет письма по сети: сохраняет их файлами .eml в Dir или,
+// если каталог не задан, выводит в лог. Только для разработки и тестов
+type logMailer struct {
+	Dir string
+}
+
+func (m logMailer) Send(to, subject, body string) error {
+	if m.Dir == "" {
+		log.Printf("Письмо для %s: %s\n%s", to, subject, body)
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strings.NewReplacer("@", "_at_", "/", "_").Replace(to) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), buildMessage("finance-tracker@localhost", to, subject, body), 0o600)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -12,6 +14,8 @@ import (
 	"os"
 
 	"github.com/gofiber/swagger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	_ "finance-tracker/docs"
 
 	"golang.org/x/crypto/bcrypt" //для хеширования пароля
@@ -21,19 +25,39 @@ import (
 
 type Transaction struct { //модель
 	ID          uint	`gorm:"primaryKey"`
-	UserID      uint       `gorm:"not null"` // Привязка к пользователю
-	Amount      float64  `gorm:"not null"`
-	Type        string   `gorm:"not null; check:type_check,type IN ('income','expense')"` //тип транзакции - трата или расход
-	Category    string  //категория - еда, одежда и тд
+	LedgerID    uint       `gorm:"not null;uniqueIndex:idx_transaction_external"` // Привязка к книге
+	CreatedByID uint       `gorm:"not null;index"` // кто добавил транзакцию
+	Amount      Money    `gorm:"not null" swaggertype:"string" example:"1500.00"` //сумма в копейках, в JSON - десятичная строка
+	Currency    string   `gorm:"size:3;not null;default:RUB"` //код валюты ISO 4217, по умолчанию базовая валюта пользователя
+	Type        string   `gorm:"not null; check:type_check,type IN ('income','expense','transfer')"` //тип транзакции - доход, расход или перевод между счетами
+	CategoryID  *uint     //категория - еда, одежда и тд, может быть не указана
+	Category    *Category `gorm:"constraint:OnDelete:SET NULL"` //заполняется только в ответах
+	Splits      []TransactionSplit `gorm:"constraint:OnDelete:CASCADE"` //разбивка по категориям, суммы частей равны Amount
+	Tags        []Tag    `gorm:"many2many:transaction_tags;constraint:OnDelete:CASCADE"` //теги по ID или по имени, новые имена создаются
+	AccountID   *uint    //счет, для перевода - счет списания
+	Account     *Account `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+	ToAccountID *uint    //счет зачисления, только для переводов
+	ToAccount   *Account `gorm:"foreignKey:ToAccountID;constraint:OnDelete:RESTRICT" json:"-"`
+	ToAmount    *Money   `swaggertype:"string"` //сумма зачисления при переводе между счетами в разных валютах
+	RecurringRuleID *uint          `gorm:"uniqueIndex:idx_recurring_occurrence"` //правило, по которому транзакция создана автоматически
+	RecurringRule   *RecurringRule `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	ExternalID  *string `gorm:"size:255;uniqueIndex:idx_transaction_external"` //идентификатор операции в банке (FITID, AcctSvcrRef) для защиты от повторного импорта
 	Description *string //может быть пустым
-	Date        time.Time 
+	Date        time.Time `gorm:"uniqueIndex:idx_recurring_occurrence"`
 	CreatedAt   time.Time //автоматически создается GORM
+	Version     uint `gorm:"not null;default:1"` //увеличивается при каждом изменении, в ответах передается и как ETag
+	DeletedAt   gorm.DeletedAt `gorm:"index" swaggertype:"string" format:"date-time"` //время перемещения в корзину, null - не удалена
 }
 
 type User struct {
 	ID uint `gorm:"primaryKey"`
-	Email string `gorm:"not null"`
+	Email string `gorm:"not null;uniqueIndex"` //хранится в нижнем регистре
 	PasswordHash string `gorm:"not null"`
+	EmailVerifiedAt *time.Time //nil - адрес еще не подтвержден
+	TOTPSecret *string //секрет TOTP в base32, задается при подключении 2FA
+	TOTPEnabledAt *time.Time //nil - двухфакторная аутентификация выключена
+	TOTPLastStep int64 `gorm:"not null;default:0"` //интервал последнего принятого кода, повторно код не принимается
+	BaseCurrency string `gorm:"size:3;not null;default:RUB"` //валюта, в которую пересчитываются баланс и отчеты
 }
 
 type authRequest struct {
@@ -52,10 +76,15 @@ func ComparePassword(hashedPassword, password string) bool {
 }
 
 //генерация токена
-func GenerateToken(id uint) (string, error) {
+func GenerateToken(id uint, jti string) (string, error) {
 
+	now := time.Now()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{ //создаем токен
 		"user_id": id,
+		"typ":     "access",
+		"jti":     jti, //идентификатор для отзыва токена
+		"iat":     now.Unix(),
+		"exp":     now.Add(accessTokenTTL()).Unix(), //короткоживущий, продлевается через /auth/refresh
 	})
 
 	secret_key := os.Getenv("JWT_SECRET")
@@ -84,13 +113,28 @@ func VerifyToken(tokenString string) (bool, error) {
 
 var db *gorm.DB
 
-// @Summary Get all transactions
-// @Description Retrieve all transactions for the authenticated user
+// @Summary Get transactions
+// @Description Retrieve transactions for the authenticated user with filtering, sorting and cursor-based pagination
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Success 200 {array} Transaction "List of transactions"
+// @Param from query string false "Start date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param type query string false "Transaction type" Enums(income, expense, transfer)
+// @Param account_id query int false "Account ID, transfers from and to the account are included"
+// @Param category_id query int false "Category ID, subcategories are included"
+// @Param min_amount query number false "Minimum amount"
+// @Param max_amount query number false "Maximum amount"
+// @Param q query string false "Search in description"
+// @Param tags query string false "Comma-separated tag IDs"
+// @Param tag_mode query string false "Match transactions with any or all of the tags" Enums(any, all) default(any)
+// @Param sort query string false "Sort field" Enums(date, amount, created_at) default(date)
+// @Param order query string false "Sort direction" Enums(asc, desc) default(desc)
+// @Param limit query int false "Page size (1-200)" default(50)
+// @Param cursor query string false "Cursor from next_cursor of the previous page"
+// @Success 200 {object} transactionPage "Page of transactions"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /api/transactions [get]
@@ -99,21 +143,39 @@ func GetTransaction(c *fiber.Ctx) error { //обрабатываем HTTP-мет
 		// error - тип, возвращаемый функцией
 		// определяем функцию, которая вызывается когда поступает запрос
 		var transactions []Transaction //создаем срез для хранения списка транзакций из бд
-		userID := c.Locals("user_id").(uint)
+		ledgerID := c.Locals("ledger_id").(uint)
 
-		db.Where("user_id = ?", userID).Find(&transactions)
-		return c.JSON(transactions)
+		query, err := parseTransactionQuery(c) //фильтры, сортировка и курсор из query-параметров
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		tx, err := query.Paginate(query.Filter(db.Preload("Category").Preload("Splits", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).Preload("Splits.Category").Preload("Tags", func(tx *gorm.DB) *gorm.DB { return tx.Order("name") }).Where("ledger_id = ?", ledgerID)))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := tx.Find(&transactions).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		page := transactionPage{Items: transactions}
+		if len(transactions) > query.Limit { //есть следующая страница
+			page.Items = transactions[:query.Limit]
+			page.NextCursor = query.NextCursor(page.Items[query.Limit-1])
+		}
+		return c.JSON(page)
 	}
 
 // @Summary Create a new transaction
 // @Summary Create a new transaction
-// @Description Create a new transaction for the authenticated user
+// @Description Create a new transaction for the authenticated user. A receipt spanning several categories can be split: Splits lists lines with their own category, amount and note, the amounts must sum to Amount and CategoryID must be empty. Tags are given by ID or by name, unknown names create new tags
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param transaction body Transaction true "Transaction data"
 // @Success 201 {object} Transaction "Created transaction"
+// @Header 201 {string} ETag "Transaction version"
 // @Failure 400 {object} map[string]string "Invalid request body or parameters"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 500 {object} map[string]string "Internal server error"
@@ -125,128 +187,204 @@ func PostTransactions(c *fiber.Ctx) error {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()}) //Map возвращает json
 		}
 
-		if transaction.Type == "" || transaction.Amount == 0 {
-			return c.Status(400).JSON(fiber.Map{"error": "Type and Amount are required"})
-		}
-
-		if transaction.Type != "income" && transaction.Type != "expense" {
-			return c.Status(400).JSON(fiber.Map{"error": "Type must be 'income' or 'expense'"})
-		}
-
 		// Если Date не передан в запросе, установим текущее время
 		if transaction.Date.IsZero() {
 			transaction.Date = time.Now()
 		}
 
-		transaction.UserID = c.Locals("user_id").(uint) // привязываем к пользователю
+		transaction.LedgerID = c.Locals("ledger_id").(uint) // привязываем к книге
+		transaction.CreatedByID = c.Locals("user_id").(uint)
+		transaction.ToAccountID = nil
+		transaction.ToAmount = nil
+		transaction.RecurringRuleID = nil
+		transaction.ExternalID = nil
+		transaction.Version = 0 // новая транзакция получает версию 1 по умолчанию
+
+		// Если не переданы ни Currency, ни счет, используем базовую валюту пользователя
+		if transaction.Currency == "" && transaction.AccountID == nil {
+			base, err := baseCurrency(transaction.CreatedByID)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			transaction.Currency = base
+		}
+		if err := validateTransaction(transaction.LedgerID, transaction); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
 
-		db.Create(transaction)
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(transaction).Error; err != nil {
+				return err
+			}
+			return recordRevision(tx, requestActor(c), revisionCreate, nil, transaction)
+		})
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Set(fiber.HeaderETag, transactionETag(transaction))
 		return c.Status(201).JSON(transaction)
 	}
 
 // @Summary Update a transaction
-// @Description Fully update a transaction by ID for the authenticated user
+// @Description Fully update a transaction by ID for the authenticated user. Omitted fields are cleared, so Type, Amount and Date are required, use PATCH to change single fields. Splits and tags are replaced as a whole, an empty list removes them. Transfers cannot be edited. Send the ETag in If-Match (or Version in the body) to make sure concurrent changes are not overwritten
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param id path int true "Transaction ID"
+// @Param If-Match header string false "ETag of the transaction the changes are based on"
 // @Param transaction body Transaction true "Full transaction data"
 // @Success 200 {object} Transaction "Updated transaction"
+// @Header 200 {string} ETag "New transaction version"
 // @Failure 400 {object} map[string]string "Invalid request body or parameters"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 404 {object} map[string]string "Transaction not found"
+// @Failure 412 {object} map[string]string "Transaction was modified by another request"
+// @Failure 500 {object} map[string]string "Internal server error"
 // @Router /api/transactions/{id} [put]
 func PutTransaction(c *fiber.Ctx) error {
 	id := c.Params("id") //получаем id из URL
-	userID := c.Locals("user_id").(uint)
+	ledgerID := c.Locals("ledger_id").(uint)
 
-	var transaction Transaction
-	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&transaction).Error; err != nil {
+	before, err := loadTransactionState(db, id, ledgerID) // состояние до изменения для истории
+	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
 	}
+	if !ifMatch(c, before) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": errVersionConflict.Error()})
+	}
 
 	updated := new(Transaction)
 	if err := c.BodyParser(updated); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
-	
-	// Обновляем все поля (кроме ID и CreatedAt)
-	transaction.Amount = updated.Amount
-	transaction.Type = updated.Type
-	transaction.Category = updated.Category
-	transaction.Description = updated.Description
-	transaction.Date = updated.Date
-
-	db.Save(&transaction)
-
-	return c.JSON(transaction)
+	return updateTransaction(c, before, updated) // обновляются все поля, кроме ID и CreatedAt
 }
 
 // @Summary Delete a transaction
-// @Description Delete a transaction by ID for the authenticated user
+// @Description Move a transaction to the trash. It can be restored with its splits, tags and attachments until it is permanently deleted after the retention period (30 days by default). The change history is kept
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Param id path int true "Transaction ID"
+// @Param If-Match header string false "ETag of the transaction"
 // @Success 200 {object} map[string]string "Success response"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
 // @Failure 404 {object} map[string]string "Transaction not found"
+// @Failure 412 {object} map[string]string "Transaction was modified by another request"
 // @Router /api/transactions/{id} [delete]
 func DeleteTransaction(c *fiber.Ctx) error {
 	id := c.Params("id")
-	userID := c.Locals("user_id").(uint)
+	ledgerID := c.Locals("ledger_id").(uint)
 
-	var transaction Transaction
-	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&transaction).Error; err != nil {
+	transaction, err := loadTransactionState(db, id, ledgerID)
+	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
 	}
+	if !ifMatch(c, transaction) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": errVersionConflict.Error()})
+	}
 
-	db.Delete(&transaction)
+	// транзакция перемещается в корзину; вложения удаляются при очистке корзины
+	deleted := *transaction
+	deleted.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	deleted.Version++
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Transaction{}).Where("id = ? AND version = ?", deleted.ID, transaction.Version).
+			Updates(map[string]interface{}{"deleted_at": deleted.DeletedAt, "version": deleted.Version})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 { // транзакцию успели изменить или удалить
+			return errVersionConflict
+		}
+		return recordRevision(tx, requestActor(c), revisionDelete, transaction, &deleted)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	return c.JSON(fiber.Map{"message": "Transaction deleted successfully"})
+	return c.JSON(fiber.Map{"message": "Transaction moved to trash"})
 }
 
 // @Summary Get user balance
-// @Description Calculate and return the balance for the authenticated user
+// @Description Calculate opening balance, income, expense and closing balance for the authenticated user over a period. Without parameters the balance is calculated over all time. Amounts are converted into the user's base currency using the rate effective on the transaction date
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
-// @Success 200 {object} map[string]float64 "Balance response"
+// @Param from query string false "Start date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param as_of query string false "Balance as of date, cannot be combined with from/to"
+// @Success 200 {object} balanceSummary "Balance response"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
 // @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 422 {object} map[string]string "Missing exchange rate or amount out of range"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /api/balance [get]
 func GetBalance(c *fiber.Ctx) error {
-	var totalIncome float64
-	var totalExpense float64
-
 	userID := c.Locals("user_id").(uint)
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	base, err := baseCurrency(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
 
-	db.Model(&Transaction{}).
-		Where("type = ? AND user_id = ?", "income", userID).
-		Select("COALESCE(SUM(amount),0)"). //COALESCE заменит NULL на 0 если подходящие записи не найдены
-		Scan(&totalIncome) //запишет результат запроса в переменную
+	summary := balanceSummary{From: from, To: to, Currency: base}
 
-	db.Model(&Transaction{}).
-		Where("type = ? AND user_id = ?", "expense", userID).
-		Select("COALESCE(SUM(amount),0)").
-		Scan(&totalExpense)
+	converted := baseAmounts(ledgerID, userID, base) //суммы в базовой валюте по курсу на дату транзакции
+	if to != nil {
+		converted = converted.Where("date < ?", *to)
+	}
+	if err := missingRate(converted, base); err != nil {
+		return conversionError(c, err)
+	}
 
-	balance := totalIncome - totalExpense
+	// одним запросом считаем остаток до начала периода и обороты внутри него
+	query := db.Table("(?) AS t", converted)
+	if from != nil {
+		query = query.Select("COALESCE(SUM(CASE WHEN date < ? THEN "+signedAmountSQL+" END),0) AS opening_balance, "+
+			"COALESCE(SUM(CASE WHEN date >= ? AND type = 'income' THEN base_amount END),0) AS income, "+
+			"COALESCE(SUM(CASE WHEN date >= ? AND type = 'expense' THEN base_amount END),0) AS expense", *from, *from, *from)
+	} else {
+		query = query.Select("0 AS opening_balance, " + //COALESCE заменит NULL на 0 если подходящие записи не найдены
+			"COALESCE(SUM(CASE WHEN type = 'income' THEN base_amount END),0) AS income, " +
+			"COALESCE(SUM(CASE WHEN type = 'expense' THEN base_amount END),0) AS expense")
+	}
+	if err := query.Scan(&summary).Error; err != nil { //запишет результат запроса в структуру
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	closing, err := summary.OpeningBalance.Add(summary.Income)
+	if err == nil {
+		closing, err = closing.Sub(summary.Expense)
+	}
+	if err != nil {
+		return conversionError(c, err)
+	}
+	summary.ClosingBalance = closing
+	summary.Balance = summary.ClosingBalance
 
-	return c.Status(200).JSON(fiber.Map{"balance": balance})
+	return c.Status(200).JSON(summary)
 }
 
 // @Summary Register a new user
-// @Description Create a new user with email and password
+// @Description Create a new user with email and password and send an email to verify the address. Login is possible after verification. If the email is already registered, the response is the same and the owner of the address gets a notice instead
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param user body authRequest true "User credentials"
 // @Success 201 {object} map[string]string "Success response"
-// @Failure 400 {object} map[string]string "Invalid request body or email already exists"
+// @Failure 400 {object} map[string]string "Invalid request body, invalid email or password"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /auth/register [post]
 func Register(c *fiber.Ctx) error {
@@ -256,30 +394,71 @@ func Register(c *fiber.Ctx) error {
             "message": err.Error(),
         })
     }
+    email, err := normalizeEmail(req.Email)
+    if err != nil {
+        return c.Status(400).JSON(fiber.Map{
+            "message": err.Error(),
+        })
+    }
+    if err := validatePassword(req.Password); err != nil {
+        return c.Status(400).JSON(fiber.Map{
+            "message": err.Error(),
+        })
+    }
+    passwordHash := GeneratePassword(req.Password) //хешируем до проверки email, чтобы время ответа не выдавало занятые адреса
+    var count int64
+    if err := db.Model(&User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+        return c.Status(500).JSON(fiber.Map{
+            "message": err.Error(),
+        })
+    }
+    if count > 0 { //ответ не отличается от успешной регистрации, владельцу адреса уходит письмо
+        go func() { //письма в обеих ветках отправляются в фоне, время ответа не зависит от почтового сервера
+            if err := sendAlreadyRegisteredEmail(email); err != nil {
+                log.Println("Ошибка отправки письма о повторной регистрации:", err)
+            }
+        }()
+        return c.Status(201).JSON(fiber.Map{
+            "message": "user created, check your email to verify the address",
+        })
+    }
     user := User{
-        Email:        req.Email,
-        PasswordHash: GeneratePassword(req.Password),
+        Email:        email,
+        PasswordHash: passwordHash,
     }
-    res := db.Create(&user)
-    if res.Error != nil {
+    err = db.Transaction(func(tx *gorm.DB) error { //вместе с пользователем создается его личная книга
+        if err := tx.Create(&user).Error; err != nil {
+            return err
+        }
+        return createPersonalLedger(tx, user.ID)
+    })
+    if err != nil {
         return c.Status(400).JSON(fiber.Map{
-            "message": res.Error.Error(),
+            "message": err.Error(),
         })
     }
+    go func() {
+        if err := sendVerificationEmail(&user); err != nil { //письмо можно запросить повторно
+            log.Println("Ошибка отправки письма подтверждения:", err)
+        }
+    }()
     return c.Status(201).JSON(fiber.Map{
-        "message": "user created",
+        "message": "user created, check your email to verify the address",
     })
 }
 
 // @Summary Login a user
-// @Description Authenticate a user and return a JWT token
+// @Description Authenticate a user and return a short-lived JWT access token and a refresh token. Repeated failures for the same email or IP are delayed with growing intervals and then locked out for a while. If two-factor authentication is enabled, the response is an mfa_token instead, to be exchanged at /auth/mfa/verify together with a code
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param credentials body authRequest true "User credentials"
-// @Success 200 {object} map[string]interface{} "Token response"
+// @Success 200 {object} tokenResponse "Token response"
+// @Success 202 {object} mfaChallenge "Second factor required"
 // @Failure 400 {object} map[string]string "Invalid request body"
 // @Failure 401 {object} map[string]string "Invalid email or password"
+// @Failure 403 {object} map[string]string "Email is not verified"
+// @Failure 429 {object} map[string]string "Too many failed attempts, see the Retry-After header"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /auth/login [post]
 func Login(c *fiber.Ctx) error {
@@ -289,33 +468,64 @@ func Login(c *fiber.Ctx) error {
             "message": err.Error(),
         })
     }
+    email := strings.ToLower(strings.TrimSpace(req.Email))
+    if throttled, err := loginThrottled(c, email, "password"); throttled || err != nil {
+        return err
+    }
     var user User
-    res := db.Where("email = ?", req.Email).First(&user)
+    res := db.Where("email = ?", email).First(&user)
+    if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+        return c.Status(500).JSON(fiber.Map{
+            "message": res.Error.Error(),
+        })
+    }
     if res.Error != nil {
-        return c.Status(400).JSON(fiber.Map{
-            "message": "user not found",
+        ComparePassword(dummyPasswordHash, req.Password) //время ответа как при неверном пароле
+        recordLoginAttempt(c, email, nil, "password", false, "unknown_email")
+        return c.Status(401).JSON(fiber.Map{
+            "message": "invalid email or password",
         })
     }
 	if !ComparePassword(user.PasswordHash, req.Password) {
-   		return c.Status(400).JSON(fiber.Map{
-        	"message": "incorrect password",
+        recordLoginAttempt(c, email, &user.ID, "password", false, "wrong_password")
+   		return c.Status(401).JSON(fiber.Map{
+        	"message": "invalid email or password",
     	})
 	}
-    token, err := GenerateToken(user.ID)
+    if user.EmailVerifiedAt == nil {
+        recordLoginAttempt(c, email, &user.ID, "password", false, "unverified")
+        return c.Status(403).JSON(fiber.Map{
+            "message": "email is not verified",
+        })
+    }
+    if user.TOTPEnabledAt != nil { //нужен второй шаг с кодом из приложения
+        mfaToken, err := generateMFAToken(user.ID)
+        if err != nil {
+            return c.Status(500).JSON(fiber.Map{
+                "message": err.Error(),
+            })
+        }
+        recordLoginAttempt(c, email, &user.ID, "password", false, "mfa_required")
+        return c.Status(202).JSON(mfaChallenge{MFARequired: true, MFAToken: mfaToken, ExpiresIn: int(mfaPendingTTL.Seconds())})
+    }
+    tokens, _, err := issueTokens(db, user.ID, "")
     if err != nil {
         return c.Status(500).JSON(fiber.Map{
             "message": err.Error(),
         })
     }
-    return c.JSON(fiber.Map{
-        "token": token,
-    })
+    recordLoginAttempt(c, email, &user.ID, "password", true, "")
+    return c.JSON(tokens)
 }
 
 func JWTProtected(c *fiber.Ctx) error { //middleware проверяющее JWT-токен
+    if token, ok := bearerPersonalToken(c); ok { //вместо JWT передан персональный токен
+        return checkPersonalAccessToken(c, token)
+    }
     return jwtware.New(jwtware.Config{ //возвращает функцию
         SigningKey: jwtware.SigningKey{Key: []byte(os.Getenv("JWT_SECRET"))},
         ContextKey: "jwt",
+        SuccessHandler: checkAccessToken, //проверяем срок действия и отзыв токена
         ErrorHandler: func(c *fiber.Ctx, err error) error { //обработчик ошибок если токен отсутствует
             return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
                 "error": true,
@@ -326,7 +536,10 @@ func JWTProtected(c *fiber.Ctx) error { //middleware проверяющее JWT-
 }
 
 func ExtractUserIDMiddleware(c *fiber.Ctx) error {
-    token := c.Locals("jwt").(*jwt.Token) // Изменено с "user" на "jwt"
+    token, ok := c.Locals("jwt").(*jwt.Token) // Изменено с "user" на "jwt"
+    if !ok { //вход по персональному токену, user_id уже установлен
+        return c.Next()
+    }
     claims := token.Claims.(jwt.MapClaims)
     userID := uint(claims["user_id"].(float64))
     c.Locals("user_id", userID)
@@ -334,7 +547,7 @@ func ExtractUserIDMiddleware(c *fiber.Ctx) error {
 }
 
 // @title Finance Tracker API
-// @description API for tracking personal finance transactions
+// @description API for tracking personal finance transactions. Data endpoints work with the ledger given in the X-Ledger-ID header, or with the personal ledger of the user if the header is absent
 // @host localhost:3000
 // @BasePath /
 // @securityDefinitions.apikey ApiKeyAuth
@@ -357,9 +570,32 @@ func main() {
 		log.Fatal("Ошибка подключения к базе данных:", err) //выводит сообщение и завершает программу
 	}
 
-	db.AutoMigrate(&Transaction{}, &User{}) //передаем указатель на созданный пустой экземпляр структуры
+	if mailer, err = newMailer(); err != nil {
+		log.Fatal("Ошибка настройки почты:", err)
+	}
+	if rateLimitStorage, err = newRateLimitStorage(); err != nil {
+		log.Fatal("Ошибка подключения к Redis:", err)
+	}
+	if blobStorage, err = newBlobStorage(); err != nil {
+		log.Fatal("Ошибка настройки хранилища вложений:", err)
+	}
 
-	app := fiber.New() //экземпляр fiber
+	if err := migrate(); err != nil {
+		log.Fatal("Ошибка миграции базы данных:", err)
+	}
+
+	config := fiber.Config{
+		BodyLimit:         attachmentMaxSize() + 1<<20, //вложение и служебные части multipart-формы; для остальных маршрутов лимит меньше, см. limitBody
+		StreamRequestBody: true,                        //тело не читается в память до проверки размера
+	}
+	if err := proxyConfig(&config); err != nil {
+		log.Fatal("Ошибка настройки прокси:", err)
+	}
+	app := fiber.New(config) //экземпляр fiber
+
+	app.Use(recover.New()) //паника в обработчике превращается в ответ 500, а не останавливает сервер
+	app.Use(requestid.New()) //X-Request-ID в ответе и в истории изменений
+	app.Use(limitBody(fiber.DefaultBodyLimit, attachmentMaxSize()+1<<20, isAttachmentUpload)) //4 МБ, как до вложений; больше только для загрузки вложения
 
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
@@ -367,17 +603,114 @@ func main() {
 
 	api.Use(JWTProtected) 
 	api.Use(ExtractUserIDMiddleware)
-
-	api.Get("/transactions", GetTransaction)
-	api.Post("/transactions", PostTransactions)
-	api.Put("/transactions/:id", PutTransaction)
-	api.Delete("/transactions/:id", DeleteTransaction)
-	api.Get("/balance", GetBalance)
+	api.Use(RateLimit("api", envLimit("API_RATE_LIMIT", 300), time.Minute, rateLimitByUser)) //запросов в минуту на пользователя
+	api.Use(LedgerAccess) //книга из заголовка X-Ledger-ID, изменения данных требуют роли editor
+
+	// маршруты, доступные персональным токенам с нужным правом
+	api.Get("/transactions", RequireScope(scopeTransactionsRead), GetTransaction)
+	api.Post("/transactions", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), PostTransactions)
+	api.Get("/transactions/:id", RequireScope(scopeTransactionsRead), GetTransactionByID)
+	api.Put("/transactions/:id", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), PutTransaction)
+	api.Patch("/transactions/:id", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), PatchTransaction)
+	api.Delete("/transactions/:id", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), DeleteTransaction)
+	api.Post("/transactions/:id/restore", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), RestoreTransaction)
+	api.Get("/trash", RequireScope(scopeTransactionsRead), GetTrash)
+	api.Get("/transactions/:id/history", RequireScope(scopeTransactionsRead), GetTransactionHistory)
+	api.Get("/transactions/:id/attachments", RequireScope(scopeTransactionsRead), GetAttachments)
+	api.Get("/transactions/:id/attachments/:attachment_id", RequireScope(scopeTransactionsRead), GetAttachment)
+	api.Post("/transactions/:id/attachments", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), PostAttachment)
+	api.Delete("/transactions/:id/attachments/:attachment_id", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), DeleteAttachment)
+	api.Post("/transfers", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), PostTransfer)
+	api.Post("/import/csv", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), ImportCSV)
+	api.Post("/import/:format", RequireScope(scopeTransactionsWrite), RequireRole(roleEditor), ImportStatement)
+	api.Get("/export", RequireScope(scopeTransactionsRead), ExportTransactions)
+	api.Get("/balance", RequireScope(scopeReportsRead), GetBalance)
+	api.Get("/balance/history", RequireScope(scopeReportsRead), GetBalanceHistory)
+	api.Get("/reports/summary", RequireScope(scopeReportsRead), GetReportSummary)
+	api.Get("/forecast", RequireScope(scopeReportsRead), GetForecast)
+
+	// остальные маршруты только при входе по паролю; запросы к маршрутам выше сюда не доходят
+	api.Use(SessionOnly)
+
+	api.Get("/accounts", GetAccounts)
+	api.Get("/accounts/balances", GetAccountBalances)
+	api.Get("/accounts/:id", GetAccount)
+	api.Post("/accounts", RequireRole(roleEditor), PostAccount)
+	api.Put("/accounts/:id", RequireRole(roleEditor), PutAccount)
+	api.Delete("/accounts/:id", RequireRole(roleEditor), DeleteAccount)
+
+	api.Get("/budgets", GetBudgets)
+	api.Get("/budgets/status", GetBudgetStatus)
+	api.Get("/budgets/:id", GetBudget)
+	api.Post("/budgets", RequireRole(roleEditor), PostBudget)
+	api.Put("/budgets/:id", RequireRole(roleEditor), PutBudget)
+	api.Delete("/budgets/:id", RequireRole(roleEditor), DeleteBudget)
+
+	api.Get("/recurring", GetRecurringRules)
+	api.Get("/recurring/:id", GetRecurringRule)
+	api.Post("/recurring", RequireRole(roleEditor), PostRecurringRule)
+	api.Put("/recurring/:id", RequireRole(roleEditor), PutRecurringRule)
+	api.Delete("/recurring/:id", RequireRole(roleEditor), DeleteRecurringRule)
+
+	api.Get("/categories", GetCategories)
+	api.Get("/categories/:id", GetCategory)
+	api.Post("/categories", RequireRole(roleEditor), PostCategory)
+	api.Put("/categories/:id", RequireRole(roleEditor), PutCategory)
+	api.Delete("/categories/:id", RequireRole(roleEditor), DeleteCategory)
+
+	api.Get("/tags", GetTags)
+	api.Get("/tags/:id", GetTag)
+	api.Post("/tags", RequireRole(roleEditor), PostTag)
+	api.Put("/tags/:id", RequireRole(roleEditor), PutTag)
+	api.Delete("/tags/:id", RequireRole(roleEditor), DeleteTag)
+
+	api.Get("/profile", GetProfile)
+	api.Put("/profile", PutProfile)
+
+	api.Get("/mfa", GetMFAStatus)
+	api.Post("/mfa/setup", SetupMFA)
+	api.Post("/mfa/enable", EnableMFA)
+	api.Post("/mfa/disable", DisableMFA)
+	api.Post("/mfa/recovery-codes", RegenerateRecoveryCodes)
+
+	api.Get("/rates", GetRates)
+	api.Post("/rates", PostRates)
+	api.Post("/rates/import", ImportRates)
+	api.Delete("/rates/:id", DeleteRate)
+
+	api.Get("/audit", GetAuditLog)
+
+	api.Get("/tokens", GetPersonalTokens)
+	api.Post("/tokens", PostPersonalToken)
+	api.Delete("/tokens/:id", DeletePersonalToken)
+
+	api.Get("/ledgers", GetLedgers)
+	api.Post("/ledgers", PostLedger)
+	api.Put("/ledgers/:id", PutLedger)
+	api.Delete("/ledgers/:id", DeleteLedger)
+	api.Get("/ledgers/:id/members", GetLedgerMembers)
+	api.Put("/ledgers/:id/members/:user_id", PutLedgerMember)
+	api.Delete("/ledgers/:id/members/:user_id", DeleteLedgerMember)
+	api.Get("/ledgers/:id/invitations", GetLedgerInvitations)
+	api.Post("/ledgers/:id/invitations", PostLedgerInvitation)
+	api.Delete("/ledgers/:id/invitations/:invitation_id", DeleteLedgerInvitation)
+	api.Post("/invitations/accept", AcceptLedgerInvitation)
 
 	// Auth
 	auth := app.Group("/auth")
+	auth.Use(RateLimit("auth", envLimit("AUTH_RATE_LIMIT", 30), time.Minute, rateLimitByIP)) //запросов в минуту с одного IP
 	auth.Post("/login", Login)
 	auth.Post("/register", Register)
+	auth.Post("/refresh", RefreshTokens)
+	auth.Post("/logout", Logout)
+	auth.Post("/mfa/verify", VerifyMFA)
+	auth.Post("/verify-email", VerifyEmail)
+	auth.Post("/verify-email/resend", ResendVerificationEmail)
+	auth.Post("/password/forgot", ForgotPassword)
+	auth.Post("/password/reset", ResetPassword)
+
+	go runRecurringScheduler() // создает транзакции по регулярным правилам
+	go runTrashPurger()        // окончательно удаляет транзакции из корзины после срока хранения
 
 	app.Listen(":3000")
 }
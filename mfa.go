@@ -0,0 +1,428 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	mfaPendingTTL     = 5 * time.Minute
+)
+
+// RecoveryCode - одноразовый код восстановления на случай потери телефона; хранится хеш
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	User      *User  `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	CodeHash  string `gorm:"size:64;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// mfaStatus - состояние двухфакторной аутентификации
+type mfaStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// mfaSetup - секрет для добавления в приложение-аутентификатор
+type mfaSetup struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"` // содержимое QR-кода
+}
+
+// mfaCodeRequest - код из приложения или код восстановления
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// mfaDisableRequest - отключение 2FA требует пароль и код
+type mfaDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// mfaVerifyRequest - второй шаг входа
+type mfaVerifyRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// mfaChallenge - ответ на первый шаг входа, если включена 2FA
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`  // обменивается на токены в /auth/mfa/verify
+	ExpiresIn   int    `json:"expires_in"` // секунд
+}
+
+// recoveryCodesResponse - новые коды восстановления, показываются один раз
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+var errMFACodeInvalid = errors.New("invalid code")
+
+// mfaIssuer - название сервиса в приложении-аутентификаторе
+func mfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Finance Tracker"
+}
+
+// normalizeRecoveryCode убирает дефисы и пробелы, чтобы код можно было ввести как угодно
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// generateRecoveryCodes заменяет коды восстановления пользователя новыми
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789" //без похожих символов 0/o, 1/l/i
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		rows[i] = RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(codes[i]))}
+	}
+	return codes, tx.Create(&rows).Error
+}
+
+// checkMFACode принимает код из приложения (один раз на интервал) или неиспользованный код восстановления
+func checkMFACode(tx *gorm.DB, user *User, code string) error {
+	if user.TOTPSecret == nil {
+		return errMFACodeInvalid
+	}
+	if step, ok := verifyTOTP(*user.TOTPSecret, code, time.Now()); ok {
+		// условие на шаг не дает повторно использовать перехваченный код
+		res := tx.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errMFACodeInvalid
+		}
+		return nil
+	}
+
+	res := tx.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errMFACodeInvalid
+	}
+	return nil
+}
+
+// generateMFAToken - короткоживущий токен первого шага входа; для /api он не подходит
+func generateMFAToken(userID uint) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"typ":     "mfa_pending",
+		"jti":     randomID(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(mfaPendingTTL).Unix(),
+	})
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// parseMFAToken проверяет токен первого шага и возвращает пользователя и jti
+func parseMFAToken(tokenString string) (uint, string, time.Time, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, "", time.Time{}, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	userID, _ := claims["user_id"].(float64)
+	jti, _ := claims["jti"].(string)
+	if claims["typ"] != "mfa_pending" || jti == "" || userID == 0 {
+		return 0, "", time.Time{}, errors.New("not an mfa token")
+	}
+	expires, err := claims.GetExpirationTime()
+	if err != nil {
+		return 0, "", time.Time{}, err
+	}
+	return uint(userID), jti, expires.Time, nil
+}
+
+// @Summary Get two-factor authentication status
+// @Description Whether TOTP is enabled and how many recovery codes are left
+// @Tags mfa
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} mfaStatus "Status"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/mfa [get]
+func GetMFAStatus(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var left int64
+	if err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&left).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(mfaStatus{Enabled: user.TOTPEnabledAt != nil, RecoveryCodesLeft: int(left)})
+}
+
+// @Summary Start TOTP enrollment
+// @Description Generate a new TOTP secret and otpauth URI for a QR code. Two-factor authentication is enabled only after a code is confirmed with /api/mfa/enable
+// @Tags mfa
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} mfaSetup "Secret and otpauth URI"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 409 {object} map[string]string "Already enabled"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/mfa/setup [post]
+func SetupMFA(c *fiber.Ctx) error {
+	var user User
+	if err := db.First(&user, c.Locals("user_id").(uint)).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user.TOTPEnabledAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "two-factor authentication is already enabled"})
+	}
+	secret := generateTOTPSecret()
+	if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(mfaSetup{Secret: secret, OtpauthURI: totpURI(mfaIssuer(), user.Email, secret)})
+}
+
+// @Summary Enable TOTP
+// @Description Confirm enrollment with a code from the authenticator app. Returns recovery codes that are shown only once
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body mfaCodeRequest true "Code from the authenticator app"
+// @Success 200 {object} recoveryCodesResponse "Recovery codes"
+// @Failure 400 {object} map[string]string "Invalid code or setup not started"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 409 {object} map[string]string "Already enabled"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/mfa/enable [post]
+func EnableMFA(c *fiber.Ctx) error {
+	var req mfaCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var user User
+	if err := db.First(&user, c.Locals("user_id").(uint)).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user.TOTPEnabledAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "two-factor authentication is already enabled"})
+	}
+	if user.TOTPSecret == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "call /api/mfa/setup first"})
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil { //коды восстановления здесь не принимаются
+			return err
+		}
+		if err := checkMFACode(tx, &user, req.Code); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("totp_enabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if errors.Is(err, errMFACodeInvalid) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// @Summary Disable TOTP
+// @Description Turn off two-factor authentication. Requires the password and a code from the app or a recovery code
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body mfaDisableRequest true "Password and code"
+// @Success 200 {object} map[string]string "Disabled"
+// @Failure 400 {object} map[string]string "Invalid password or code, or not enabled"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/mfa/disable [post]
+func DisableMFA(c *fiber.Ctx) error {
+	var req mfaDisableRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var user User
+	if err := db.First(&user, c.Locals("user_id").(uint)).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user.TOTPEnabledAt == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "two-factor authentication is not enabled"})
+	}
+	if !ComparePassword(user.PasswordHash, req.Password) {
+		return c.Status(400).JSON(fiber.Map{"error": "incorrect password"})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkMFACode(tx, &user, req.Code); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{"totp_secret": nil, "totp_enabled_at": nil}).Error
+	})
+	if errors.Is(err, errMFACodeInvalid) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "two-factor authentication disabled"})
+}
+
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes with new ones. Requires a code from the authenticator app
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body mfaCodeRequest true "Code from the authenticator app"
+// @Success 200 {object} recoveryCodesResponse "New recovery codes"
+// @Failure 400 {object} map[string]string "Invalid code or not enabled"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/mfa/recovery-codes [post]
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var req mfaCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var user User
+	if err := db.First(&user, c.Locals("user_id").(uint)).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if user.TOTPEnabledAt == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "two-factor authentication is not enabled"})
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, ok := verifyTOTP(*user.TOTPSecret, req.Code, time.Now()); !ok { //только код из приложения
+			return errMFACodeInvalid
+		}
+		if err := checkMFACode(tx, &user, req.Code); err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if errors.Is(err, errMFACodeInvalid) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// @Summary Complete login with a second factor
+// @Description Exchange the mfa_token from /auth/login and a code from the authenticator app (or a recovery code) for an access token and a refresh token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body mfaVerifyRequest true "MFA token and code"
+// @Success 200 {object} tokenResponse "Token response"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]string "Invalid or expired mfa token, or invalid code"
+// @Failure 429 {object} map[string]string "Too many failed attempts, see the Retry-After header"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/mfa/verify [post]
+func VerifyMFA(c *fiber.Ctx) error {
+	var req mfaVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": err.Error()})
+	}
+	userID, jti, expires, err := parseMFAToken(req.MFAToken)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"message": "invalid or expired mfa token"})
+	}
+	var account User
+	if err := db.First(&account, userID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(401).JSON(fiber.Map{"message": "invalid or expired mfa token"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	// перебор кодов ограничивается так же, как перебор паролей
+	if throttled, err := loginThrottled(c, account.Email, "mfa"); throttled || err != nil {
+		return err
+	}
+
+	var tokens *tokenResponse
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// токен первого шага одноразовый: после входа его jti попадает в отозванные
+		var count int64
+		if err := tx.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errMFACodeInvalid
+		}
+		var user User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if err := checkMFACode(tx, &user, req.Code); err != nil {
+			return err
+		}
+		if err := tx.Create(&RevokedToken{JTI: jti, ExpiresAt: expires}).Error; err != nil {
+			return err
+		}
+		var err error
+		tokens, _, err = issueTokens(tx, user.ID, "")
+		return err
+	})
+	if errors.Is(err, errMFACodeInvalid) || errors.Is(err, gorm.ErrRecordNotFound) {
+		recordLoginAttempt(c, account.Email, &account.ID, "mfa", false, "invalid_code")
+		return c.Status(401).JSON(fiber.Map{"message": "invalid code"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"message": err.Error()})
+	}
+	recordLoginAttempt(c, account.Email, &account.ID, "mfa", true, "")
+	return c.JSON(tokens)
+}
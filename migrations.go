@@ -0,0 +1,49 @@
+package main
+
+import "log"
+
+// migrate приводит схему бд к текущим моделям.
+// Изменения, которые AutoMigrate не умеет делать с сохранением данных, выполняются до него
+func migrate() error {
+	if err := migrateMoneyColumns(); err != nil {
+		return err
+	}
+	if err := migrateTransferType(); err != nil {
+		return err
+	}
+	if err := migrateUserEmails(); err != nil {
+		return err
+	}
+	if err := migrateLedgers(); err != nil {
+		return err
+	}
+	if err := migrateRevisionActions(); err != nil {
+		return err
+	}
+	if err := migrateBudgetCurrency(); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&User{}, &Category{}, &Account{}, &RecurringRule{}, &Transaction{}, &ExchangeRate{}, &Budget{}, &RefreshToken{}, &RevokedToken{}, &UserToken{}, &RecoveryCode{}, &LoginAttempt{}, &PersonalAccessToken{}, &TransactionSplit{}, &Tag{}, &Attachment{}, &TransactionRevision{}, &Ledger{}, &LedgerMember{}, &LedgerInvitation{}); err != nil { //передаем указатель на созданный пустой экземпляр структуры
+		return err
+	}
+	return migrateLegacyCategories()
+}
+
+// columnType возвращает тип колонки из information_schema или пустую строку, если ее нет
+func columnType(table, column string) (string, error) {
+	var dataType string
+	err := db.Raw("SELECT data_type FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = ?", table, column).
+		Scan(&dataType).Error
+	return dataType, err
+}
+
+// migrateMoneyColumns переводит суммы из double precision в numeric(18,2),
+// округляя накопившиеся погрешности до копеек
+func migrateMoneyColumns() error {
+	dataType, err := columnType("transactions", "amount")
+	if err != nil || dataType != "double precision" {
+		return err
+	}
+	log.Println("Миграция: transactions.amount double precision -> numeric(18,2)")
+	return db.Exec("ALTER TABLE transactions ALTER COLUMN amount TYPE numeric(18,2) USING ROUND(amount::numeric, 2)").Error
+}
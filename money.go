@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money - денежная сумма в минимальных единицах (сотых долях валюты).
+// В бд хранится как numeric(18,2), в JSON передается десятичной строкой "1234.50"
+type Money int64
+
+var errInvalidMoney = errors.New("amount must be a decimal number with at most 2 fractional digits")
+
+// errMoneyOverflow - результат арифметики не помещается в int64
+var errMoneyOverflow = errors.New("amount out of range")
+
+// ParseMoney разбирает десятичную строку без потери точности
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" || len(frac) > 2 || len(whole) > 16 {
+		return 0, errInvalidMoney
+	}
+	for _, r := range whole + frac {
+		if r < '0' || r > '9' {
+			return 0, errInvalidMoney
+		}
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, errInvalidMoney
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money(minor), nil
+}
+
+// String возвращает сумму с двумя знаками после точки
+func (m Money) String() string {
+	sign := ""
+	minor := int64(m)
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/100, minor%100)
+}
+
+// Add складывает суммы; при переполнении int64 возвращает errMoneyOverflow
+func (m Money) Add(o Money) (Money, error) {
+	sum := m + o
+	if (o > 0 && sum < m) || (o < 0 && sum > m) {
+		return 0, errMoneyOverflow
+	}
+	return sum, nil
+}
+
+// Sub вычитает суммы; при переполнении int64 возвращает errMoneyOverflow
+func (m Money) Sub(o Money) (Money, error) {
+	if o == math.MinInt64 {
+		return 0, errMoneyOverflow
+	}
+	return m.Add(-o)
+}
+
+// Mul умножает сумму на целое число; при переполнении int64 возвращает errMoneyOverflow
+func (m Money) Mul(n int64) (Money, error) {
+	if m == 0 || n == 0 {
+		return 0, nil
+	}
+	product := m * Money(n)
+	if product/Money(n) != m || (m == -1 && n == math.MinInt64) || (n == -1 && m == math.MinInt64) {
+		return 0, errMoneyOverflow
+	}
+	return product, nil
+}
+
+// Cmp возвращает -1, 0 или 1
+func (m Money) Cmp(o Money) int {
+	switch {
+	case m < o:
+		return -1
+	case m > o:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.String() + `"`), nil
+}
+
+// UnmarshalJSON принимает как строку "12.50", так и число 12.5
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case string:
+		return m.scanString(v)
+	case []byte:
+		return m.scanString(string(v))
+	case int64:
+		minor, err := Money(v).Mul(100)
+		if err != nil {
+			return err
+		}
+		*m = minor
+		return nil
+	case float64:
+		return m.scanString(strconv.FormatFloat(v, 'f', 2, 64))
+	}
+	return fmt.Errorf("money: cannot scan %T", src)
+}
+
+// scanString разбирает значение numeric из бд; лишние знаки после точки
+// округляются до сотых (половина - от нуля)
+func (m *Money) scanString(s string) error {
+	roundUp := false
+	if whole, frac, ok := strings.Cut(s, "."); ok && len(frac) > 2 {
+		roundUp = frac[2] >= '5'
+		s = whole + "." + frac[:2]
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		// число из базы записано верно, но не помещается в Money
+		if whole, _, _ := strings.Cut(strings.TrimLeft(s, "+-"), "."); len(whole) > 16 && strings.Trim(whole, "0123456789") == "" {
+			return errMoneyOverflow
+		}
+		return err
+	}
+	if roundUp {
+		if strings.HasPrefix(s, "-") {
+			parsed--
+		} else {
+			parsed++
+		}
+	}
+	*m = parsed
+	return nil
+}
+
+// GormDataType задает тип колонки для AutoMigrate
+func (Money) GormDataType() string {
+	return "numeric(18,2)"
+}
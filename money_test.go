@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "12", want: 1200},
+		{in: "12.5", want: 1250},
+		{in: "1234.56", want: 123456},
+		{in: " 7.01 ", want: 701},
+		{in: "+3", want: 300},
+		{in: "-0.05", want: -5},
+		{in: ".5", want: 50},
+		{in: "0", want: 0},
+		{in: "9999999999999999.99", want: 999999999999999999},
+		{in: "-9999999999999999.99", want: -999999999999999999},
+		{in: "10000000000000000", wantErr: true}, // больше 16 цифр в целой части
+		{in: "0.005", wantErr: true},
+		{in: "1.234", wantErr: true},
+		{in: "1,5", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "--1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{123456, "1234.56"},
+		{-100, "-1.00"},
+		{math.MaxInt64, "92233720368547758.07"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyAddSub(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Money
+		sum     Money
+		sumErr  bool
+		diff    Money
+		diffErr bool
+	}{
+		{name: "small", a: 150, b: 50, sum: 200, diff: 100},
+		{name: "negative", a: -150, b: 50, sum: -100, diff: -200},
+		{name: "max plus zero", a: math.MaxInt64, b: 0, sum: math.MaxInt64, diff: math.MaxInt64},
+		{name: "max plus one", a: math.MaxInt64, b: 1, sumErr: true, diff: math.MaxInt64 - 1},
+		{name: "max minus minus one", a: math.MaxInt64, b: -1, sum: math.MaxInt64 - 1, diffErr: true},
+		{name: "min minus one", a: math.MinInt64, b: 1, sum: math.MinInt64 + 1, diffErr: true},
+		{name: "min plus minus one", a: math.MinInt64, b: -1, sumErr: true, diff: math.MinInt64 + 1},
+		{name: "subtract min", a: 0, b: math.MinInt64, sum: math.MinInt64, diffErr: true},
+		{name: "subtract min from min", a: math.MinInt64, b: math.MinInt64, sumErr: true, diffErr: true},
+		{name: "max and min", a: math.MaxInt64, b: math.MinInt64, sum: -1, diffErr: true},
+		{name: "largest amounts", a: 999999999999999999, b: 999999999999999999, sum: 1999999999999999998, diff: 0},
+	}
+	for _, tt := range tests {
+		sum, err := tt.a.Add(tt.b)
+		if tt.sumErr {
+			if !errors.Is(err, errMoneyOverflow) {
+				t.Errorf("%s: Add = %v, %v, want overflow", tt.name, sum, err)
+			}
+		} else if err != nil || sum != tt.sum {
+			t.Errorf("%s: Add = %v, %v, want %v", tt.name, sum, err, tt.sum)
+		}
+
+		diff, err := tt.a.Sub(tt.b)
+		if tt.diffErr {
+			if !errors.Is(err, errMoneyOverflow) {
+				t.Errorf("%s: Sub = %v, %v, want overflow", tt.name, diff, err)
+			}
+		} else if err != nil || diff != tt.diff {
+			t.Errorf("%s: Sub = %v, %v, want %v", tt.name, diff, err, tt.diff)
+		}
+	}
+}
+
+func TestMoneyMul(t *testing.T) {
+	tests := []struct {
+		a       Money
+		n       int64
+		want    Money
+		wantErr bool
+	}{
+		{a: 1500000, n: 12, want: 18000000},
+		{a: 0, n: math.MaxInt64, want: 0},
+		{a: 999999999999999999, n: 9, want: 8999999999999999991},
+		{a: 999999999999999999, n: 10, wantErr: true},
+		{a: math.MaxInt64, n: 2, wantErr: true},
+		{a: math.MinInt64, n: -1, wantErr: true},
+		{a: -1, n: math.MinInt64, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tt.a.Mul(tt.n)
+		if tt.wantErr {
+			if !errors.Is(err, errMoneyOverflow) {
+				t.Errorf("Money(%d).Mul(%d) = %v, %v, want overflow", int64(tt.a), tt.n, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Money(%d).Mul(%d) = %v, %v, want %v", int64(tt.a), tt.n, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: `"1500.00"`, want: 150000},
+		{in: `12.5`, want: 1250},
+		{in: `"-0.10"`, want: -10},
+		{in: `null`, want: 0},
+		{in: `"1.001"`, wantErr: true},
+		{in: `"abc"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Unmarshal(%s) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	out, err := json.Marshal(struct{ Amount Money }{150050})
+	if err != nil || string(out) != `{"Amount":"1500.50"}` {
+		t.Errorf("Marshal = %s, %v", out, err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want Money
+	}{
+		{in: "1234.50", want: 123450},
+		{in: []byte("10"), want: 1000},
+		{in: "1.005", want: 101}, // половина округляется от нуля
+		{in: "-1.005", want: -101},
+		{in: "1.0049", want: 100},
+		{in: int64(7), want: 700},
+		{in: nil, want: 0},
+	}
+	for _, tt := range tests {
+		got := Money(42)
+		if err := got.Scan(tt.in); err != nil || got != tt.want {
+			t.Errorf("Scan(%v) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	// сумма из базы, которая не помещается в Money
+	for _, in := range []interface{}{int64(math.MaxInt64 / 10), int64(math.MinInt64 / 10), "123456789012345678.00", "-12345678901234567", 1e30} {
+		var got Money
+		if err := got.Scan(in); !errors.Is(err, errMoneyOverflow) {
+			t.Errorf("Scan(%v) = %v, %v, want errMoneyOverflow", in, got, err)
+		}
+	}
+	var got Money
+	if err := got.Scan("12a"); !errors.Is(err, errInvalidMoney) {
+		t.Errorf("Scan(12a) = %v, want errInvalidMoney", err)
+	}
+}
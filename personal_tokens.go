@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// права персональных токенов
+const (
+	scopeTransactionsRead  = "transactions:read"  // просмотр и экспорт транзакций
+	scopeTransactionsWrite = "transactions:write" // создание, изменение, удаление, импорт и переводы
+	scopeReportsRead       = "reports:read"       // баланс, отчеты и прогноз
+)
+
+var personalTokenScopes = []string{scopeTransactionsRead, scopeTransactionsWrite, scopeReportsRead}
+
+const (
+	personalTokenPrefix      = "ftp_" // по префиксу токен отличается от JWT в заголовке Authorization
+	personalTokenDefaultDays = 90
+	personalTokenMaxDays     = 365
+)
+
+// PersonalAccessToken - токен для скриптов и интеграций вместо пароля. В бд хранится только хеш
+type PersonalAccessToken struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"not null;index"`
+	User       *User     `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Name       string    `gorm:"size:100;not null"`
+	Prefix     string    `gorm:"size:12;not null"`             //начало токена, чтобы пользователь узнал его в списке
+	TokenHash  string    `gorm:"size:64;not null;uniqueIndex"` //sha256 от токена
+	Scopes     string    `gorm:"size:255;not null"`            //права через запятую
+	ExpiresAt  time.Time `gorm:"not null"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// personalTokenRequest - тело запроса на создание токена
+type personalTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`          // transactions:read, transactions:write, reports:read
+	ExpiresInDays int      `json:"expires_in_days"` // по умолчанию 90, не больше 365
+}
+
+// personalTokenResponse - токен в списке; сам токен показывается только при создании
+type personalTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Token      string     `json:"token,omitempty"` // только в ответе на создание
+}
+
+func newPersonalTokenResponse(token *PersonalAccessToken) personalTokenResponse {
+	return personalTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     strings.Split(token.Scopes, ","),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// bearerPersonalToken возвращает персональный токен из заголовка Authorization, если передан он, а не JWT
+func bearerPersonalToken(c *fiber.Ctx) (string, bool) {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, strings.HasPrefix(token, personalTokenPrefix)
+}
+
+// checkPersonalAccessToken - вход по персональному токену: ставит user_id и права токена
+func checkPersonalAccessToken(c *fiber.Ctx, raw string) error {
+	var token PersonalAccessToken
+	err := db.Where("token_hash = ?", hashToken(raw)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": true, "msg": "invalid personal access token"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": true, "msg": err.Error()})
+	}
+	now := time.Now()
+	if now.After(token.ExpiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": true, "msg": "personal access token expired"})
+	}
+	// время использования обновляется не чаще раза в минуту, чтобы не писать в бд на каждый запрос
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		if err := db.Model(&token).UpdateColumn("last_used_at", now).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": true, "msg": err.Error()})
+		}
+	}
+	c.Locals("user_id", token.UserID)
+	c.Locals("token_scopes", strings.Split(token.Scopes, ","))
+	return c.Next()
+}
+
+// RequireScope пропускает запрос по персональному токену только с нужным правом.
+// При входе по паролю (JWT) доступны все маршруты
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scopes, ok := c.Locals("token_scopes").([]string)
+		if ok && !slices.Contains(scopes, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "token does not have the " + scope + " scope"})
+		}
+		return c.Next()
+	}
+}
+
+// SessionOnly закрывает маршруты от персональных токенов: управление аккаунтом, токенами и справочниками
+func SessionOnly(c *fiber.Ctx) error {
+	if _, ok := c.Locals("token_scopes").([]string); ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "not available with a personal access token"})
+	}
+	return c.Next()
+}
+
+// @Summary List personal access tokens
+// @Description Personal access tokens of the current user. The tokens themselves are never shown again after creation
+// @Tags tokens
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} personalTokenResponse "Tokens"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not available with a personal access token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/tokens [get]
+func GetPersonalTokens(c *fiber.Ctx) error {
+	var tokens []PersonalAccessToken
+	if err := db.Where("user_id = ?", c.Locals("user_id").(uint)).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	response := make([]personalTokenResponse, 0, len(tokens))
+	for i := range tokens {
+		response = append(response, newPersonalTokenResponse(&tokens[i]))
+	}
+	return c.JSON(response)
+}
+
+// @Summary Create a personal access token
+// @Description Create a token for scripts and integrations, used as "Authorization: Bearer ftp_...". Scopes: transactions:read, transactions:write, reports:read. The token is returned only in this response
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body personalTokenRequest true "Token name, scopes and lifetime"
+// @Success 201 {object} personalTokenResponse "Created token"
+// @Failure 400 {object} map[string]string "Invalid name, scopes or lifetime"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not available with a personal access token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/tokens [post]
+func PostPersonalToken(c *fiber.Ctx) error {
+	var req personalTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "name is required and must be at most 100 characters"})
+	}
+	if len(req.Scopes) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "at least one scope is required: " + strings.Join(personalTokenScopes, ", ")})
+	}
+	var scopes []string
+	for _, scope := range req.Scopes {
+		if !slices.Contains(personalTokenScopes, scope) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown scope " + scope + ", expected " + strings.Join(personalTokenScopes, ", ")})
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = personalTokenDefaultDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > personalTokenMaxDays {
+		return c.Status(400).JSON(fiber.Map{"error": "expires_in_days must be between 1 and 365"})
+	}
+
+	raw := personalTokenPrefix + randomToken(32)
+	token := PersonalAccessToken{
+		UserID:    c.Locals("user_id").(uint),
+		Name:      req.Name,
+		Prefix:    raw[:12],
+		TokenHash: hashToken(raw),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	response := newPersonalTokenResponse(&token)
+	response.Token = raw
+	return c.Status(201).JSON(response)
+}
+
+// @Summary Revoke a personal access token
+// @Description Delete a personal access token; requests with it are rejected immediately
+// @Tags tokens
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Token ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 403 {object} map[string]string "Not available with a personal access token"
+// @Failure 404 {object} map[string]string "Token not found"
+// @Router /api/tokens/{id} [delete]
+func DeletePersonalToken(c *fiber.Ctx) error {
+	res := db.Where("id = ? AND user_id = ?", c.Params("id"), c.Locals("user_id").(uint)).Delete(&PersonalAccessToken{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Token not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Token revoked successfully"})
+}
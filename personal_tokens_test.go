@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// scopedApp - приложение, в котором права токена берутся из заголовка X-Scopes;
+// без заголовка запрос считается входом по паролю
+func scopedApp(handlers ...fiber.Handler) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if scopes := c.Get("X-Scopes"); scopes != "" {
+			c.Locals("token_scopes", strings.Split(scopes, ","))
+		}
+		return c.Next()
+	})
+	app.Get("/", append(handlers, func(c *fiber.Ctx) error { return c.SendString("ok") })...)
+	return app
+}
+
+func TestRequireScopeAndSessionOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler fiber.Handler
+		scopes  string // пусто - вход по паролю
+		want    int
+	}{
+		{name: "password login", handler: RequireScope(scopeTransactionsWrite), want: 200},
+		{name: "token with the scope", handler: RequireScope(scopeTransactionsWrite), scopes: "transactions:read,transactions:write", want: 200},
+		{name: "token without the scope", handler: RequireScope(scopeTransactionsWrite), scopes: "transactions:read", want: fiber.StatusForbidden},
+		{name: "read scope does not grant reports", handler: RequireScope(scopeReportsRead), scopes: "transactions:read", want: fiber.StatusForbidden},
+		{name: "session route with password login", handler: SessionOnly, want: 200},
+		{name: "session route with a token", handler: SessionOnly, scopes: "transactions:read,transactions:write,reports:read", want: fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.scopes != "" {
+			req.Header.Set("X-Scopes", tt.scopes)
+		}
+		resp, err := scopedApp(tt.handler).Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestBearerPersonalToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{header: "Bearer ftp_abc123", token: "ftp_abc123", ok: true},
+		{header: "bearer  ftp_abc123 ", token: "ftp_abc123", ok: true},
+		{header: "Bearer eyJhbGciOiJIUzI1NiJ9.e30.sig", token: "eyJhbGciOiJIUzI1NiJ9.e30.sig", ok: false},
+		{header: "Basic ftp_abc123", ok: false},
+		{header: "", ok: false},
+	}
+	for _, tt := range tests {
+		var token string
+		var ok bool
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			token, ok = bearerPersonalToken(c)
+			return nil
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set(fiber.HeaderAuthorization, tt.header)
+		}
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok || (tt.token != "" && token != tt.token) {
+			t.Errorf("bearerPersonalToken(%q) = %q, %v, want %q, %v", tt.header, token, ok, tt.token, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/storage/redis/v3"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// rateLimitStorage - общее хранилище счетчиков запросов. nil - память процесса;
+// при нескольких экземплярах сервера нужен общий Redis (или совместимый: Valkey, KeyDB)
+var rateLimitStorage fiber.Storage
+
+// newRateLimitStorage выбирает хранилище счетчиков по переменной REDIS_URL.
+// Адрес и доступность Redis проверяются здесь: redis.New при ошибке паникует
+func newRateLimitStorage() (fiber.Storage, error) {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		return nil, nil
+	}
+	options, err := goredis.ParseURL(redisURL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) { //без самого адреса: в нем может быть пароль
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := goredis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Redis from REDIS_URL is unavailable: %w", err)
+	}
+	return redis.NewFromConnection(client), nil
+}
+
+// proxyConfig настраивает определение IP клиента за обратным прокси. IP берется
+// из заголовка PROXY_HEADER (по умолчанию X-Forwarded-For) только для запросов
+// с адресов из TRUSTED_PROXIES (IP или CIDR через запятую); без TRUSTED_PROXIES
+// заголовок не читается, иначе клиент мог бы подменить IP и обойти лимиты
+func proxyConfig(config *fiber.Config) error {
+	proxies := os.Getenv("TRUSTED_PROXIES")
+	if proxies == "" {
+		return nil
+	}
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("TRUSTED_PROXIES: %q is not an IP address or CIDR", proxy)
+			}
+		}
+		config.TrustedProxies = append(config.TrustedProxies, proxy)
+	}
+	config.EnableTrustedProxyCheck = true
+	config.EnableIPValidation = true //из списка адресов в заголовке берется первый корректный
+	config.ProxyHeader = os.Getenv("PROXY_HEADER")
+	if config.ProxyHeader == "" {
+		config.ProxyHeader = fiber.HeaderXForwardedFor
+	}
+	return nil
+}
+
+// envLimit читает лимит запросов из переменной окружения
+func envLimit(name string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// RateLimit ограничивает число запросов за окно скользящим счетчиком. key выделяет,
+// чьи запросы считаются вместе (IP, пользователь); prefix разделяет счетчики разных групп
+func RateLimit(prefix string, max int, window time.Duration, key func(c *fiber.Ctx) string) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: window,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return "ratelimit:" + prefix + ":" + key(c)
+		},
+		LimitReached: func(c *fiber.Ctx) error { //заголовок Retry-After уже выставлен
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "too many requests, try again later"})
+		},
+		Storage:           rateLimitStorage,
+		LimiterMiddleware: limiter.SlidingWindow{},
+	})
+}
+
+// rateLimitByIP - ключ для маршрутов без аутентификации
+func rateLimitByIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// rateLimitByUser - ключ для /api, ставится после ExtractUserIDMiddleware
+func rateLimitByUser(c *fiber.Ctx) string {
+	return strconv.FormatUint(uint64(c.Locals("user_id").(uint)), 10)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestEnvLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{value: "", want: 30},
+		{value: "100", want: 100},
+		{value: "0", want: 30},
+		{value: "-5", want: 30},
+		{value: "many", want: 30},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_RATE_LIMIT", tt.value)
+		if got := envLimit("TEST_RATE_LIMIT", 30); got != tt.want {
+			t.Errorf("envLimit(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", uint(c.QueryInt("user")))
+		return c.Next()
+	})
+	app.Use(RateLimit("test", 2, time.Minute, rateLimitByUser))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	tests := []struct {
+		user string
+		want int
+	}{
+		{user: "1", want: 200},
+		{user: "1", want: 200},
+		{user: "1", want: fiber.StatusTooManyRequests},
+		{user: "2", want: 200}, // счетчики пользователей независимы
+	}
+	for i, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "/?user="+tt.user, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("request %d: status %d, want %d", i, resp.StatusCode, tt.want)
+		}
+		if tt.want == fiber.StatusTooManyRequests && resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+			t.Errorf("request %d: no Retry-After header", i)
+		}
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	// app.Test подключается с адреса 0.0.0.0
+	tests := []struct {
+		proxies, header string
+		want            string
+	}{
+		{want: "0.0.0.0"}, // без доверенных прокси заголовок не читается
+		{proxies: "10.0.0.1", want: "0.0.0.0"},
+		{proxies: "10.0.0.1, 0.0.0.0", want: "203.0.113.7"},
+		{proxies: "0.0.0.0/8", want: "203.0.113.7"},
+		{proxies: "0.0.0.0", header: "X-Real-IP", want: "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRUSTED_PROXIES", tt.proxies)
+		t.Setenv("PROXY_HEADER", tt.header)
+		var config fiber.Config
+		if err := proxyConfig(&config); err != nil {
+			t.Fatalf("proxyConfig(%q): %v", tt.proxies, err)
+		}
+		app := fiber.New(config)
+		app.Get("/", func(c *fiber.Ctx) error { return c.SendString(rateLimitByIP(c)) })
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+		req.Header.Set("X-Real-IP", "198.51.100.1")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != tt.want {
+			t.Errorf("TRUSTED_PROXIES=%q PROXY_HEADER=%q: IP %s, want %s", tt.proxies, tt.header, body, tt.want)
+		}
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1,proxy.local")
+	if err := proxyConfig(&fiber.Config{}); err == nil {
+		t.Error("proxyConfig with a host name: want error")
+	}
+}
+
+func TestNewRateLimitStorage(t *testing.T) {
+	t.Setenv("REDIS_URL", "")
+	if storage, err := newRateLimitStorage(); storage != nil || err != nil {
+		t.Errorf("without REDIS_URL = %v, %v, want memory storage", storage, err)
+	}
+	for _, url := range []string{"http://localhost:6379", "redis://:secret@localhost:port/0", "redis://localhost/db"} {
+		t.Setenv("REDIS_URL", url)
+		_, err := newRateLimitStorage()
+		if err == nil {
+			t.Errorf("REDIS_URL=%s: want error", url)
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("REDIS_URL=%s: error reveals the password: %v", url, err)
+		}
+	}
+}
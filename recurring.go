@@ -0,0 +1,424 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// сколько пропущенных повторений создается за один проход планировщика по одному правилу
+const maxCatchUpPerRun = 500
+
+// наибольший шаг расписания: каждые 1000 дней/недель/месяцев/лет
+const maxRecurringInterval = 1000
+
+// на сколько лет назад может начинаться новое правило; все повторения
+// с StartDate создаются задним числом, поэтому глубина ограничена
+const maxRecurringBackfillYears = 1
+
+// RecurringRule - расписание регулярной операции (зарплата, аренда, подписки)
+type RecurringRule struct {
+	ID          uint      `gorm:"primaryKey"`
+	LedgerID    uint      `gorm:"not null;index"`
+	CreatedByID uint      `gorm:"not null;index"` //автор правила, он же автор создаваемых транзакций
+	Type        string    `gorm:"not null;check:recurring_type_check,type IN ('income','expense')"`
+	Amount      Money     `gorm:"not null" swaggertype:"string" example:"50000.00"`
+	Currency    string    `gorm:"size:3;not null"`
+	CategoryID  *uint     //категория создаваемых транзакций
+	Category    *Category `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	AccountID   *uint     //счет создаваемых транзакций
+	Account     *Account  `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	Description *string
+	Frequency   string     `gorm:"not null;check:recurring_frequency_check,frequency IN ('daily','weekly','monthly','yearly')"`
+	Interval    int        `gorm:"column:interval_count;not null;default:1"` //каждые N дней/недель/месяцев/лет
+	DayOfMonth  *int       //для monthly и yearly; в коротких месяцах берется последний день
+	StartDate   time.Time  `gorm:"not null"` //первое повторение
+	EndDate     *time.Time //после этой даты повторения не создаются
+	NextRun     time.Time  `gorm:"not null;index"`              //дата следующего еще не созданного повторения
+	NextIndex   int        `gorm:"not null;default:0" json:"-"` //номер следующего повторения от StartDate
+	CreatedAt   time.Time
+}
+
+// occurrence возвращает дату n-го повторения (n от нуля).
+// Считается от StartDate, поэтому 31-е число не "сползает" после февраля
+func (r *RecurringRule) occurrence(n int) time.Time {
+	start := r.StartDate
+	step := n * r.Interval
+	switch r.Frequency {
+	case "daily":
+		return start.AddDate(0, 0, step)
+	case "weekly":
+		return start.AddDate(0, 0, 7*step)
+	}
+
+	year, month := start.Year(), start.Month()
+	if r.Frequency == "yearly" {
+		year += step
+	} else {
+		month += time.Month(step)
+	}
+	day := start.Day()
+	if r.DayOfMonth != nil {
+		day = *r.DayOfMonth
+	}
+	// нормализуем месяц и ограничиваем день его длиной
+	first := time.Date(year, month, 1, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// finished сообщает, что повторение t выходит за дату окончания правила
+func (r *RecurringRule) finished(t time.Time) bool {
+	return r.EndDate != nil && t.After(*r.EndDate)
+}
+
+// occurrencesBetween возвращает еще не созданные повторения правила в интервале [from, to)
+func (r *RecurringRule) occurrencesBetween(from, to time.Time) []time.Time {
+	var dates []time.Time
+	n := r.NextIndex
+	if first := r.firstIndex(from); first > n {
+		n = first
+	}
+	for ; ; n++ {
+		t := r.occurrence(n)
+		if !t.Before(to) || r.finished(t) {
+			return dates
+		}
+		dates = append(dates, t)
+	}
+}
+
+// overdue возвращает число еще не созданных повторений раньше before
+func (r *RecurringRule) overdue(before time.Time) int {
+	n := r.firstIndex(before)
+	if r.EndDate != nil {
+		if last := r.firstIndex(r.EndDate.Add(time.Nanosecond)); last < n {
+			n = last
+		}
+	}
+	if n < r.NextIndex {
+		return 0
+	}
+	return n - r.NextIndex
+}
+
+// firstIndex возвращает номер первого повторения не раньше since.
+// Номер оценивается по календарю, а не перебором от StartDate
+func (r *RecurringRule) firstIndex(since time.Time) int {
+	start := r.StartDate
+	var steps int64
+	switch r.Frequency {
+	case "daily":
+		steps = (since.Unix() - start.Unix()) / 86400
+	case "weekly":
+		steps = (since.Unix() - start.Unix()) / (7 * 86400)
+	case "monthly":
+		steps = int64(since.Year()-start.Year())*12 + int64(since.Month()-start.Month())
+	case "yearly":
+		steps = int64(since.Year() - start.Year())
+	}
+	// оценка может ошибиться на шаг из-за перехода на летнее время и длины месяцев
+	n := 0
+	if steps > 0 {
+		n = int(steps / int64(r.Interval))
+	}
+	for n > 0 && !r.occurrence(n-1).Before(since) {
+		n--
+	}
+	for r.occurrence(n).Before(since) {
+		n++
+	}
+	return n
+}
+
+// validateRecurringRule проверяет правило и подставляет значения по умолчанию
+func validateRecurringRule(rule *RecurringRule) error {
+	if rule.Type != "income" && rule.Type != "expense" {
+		return errors.New("Type must be 'income' or 'expense'")
+	}
+	if rule.Amount <= 0 {
+		return errors.New("Amount must be positive")
+	}
+	switch rule.Frequency {
+	case "daily", "weekly", "monthly", "yearly":
+	default:
+		return errors.New("Frequency must be one of: daily, weekly, monthly, yearly")
+	}
+	if rule.Interval == 0 {
+		rule.Interval = 1
+	}
+	if rule.Interval < 0 || rule.Interval > maxRecurringInterval {
+		return errors.New("Interval must be between 1 and 1000")
+	}
+	if rule.DayOfMonth != nil {
+		if rule.Frequency != "monthly" && rule.Frequency != "yearly" {
+			return errors.New("DayOfMonth is only allowed for monthly and yearly rules")
+		}
+		if *rule.DayOfMonth < 1 || *rule.DayOfMonth > 31 {
+			return errors.New("DayOfMonth must be between 1 and 31")
+		}
+	}
+	if rule.StartDate.IsZero() {
+		return errors.New("StartDate is required")
+	}
+	if rule.EndDate != nil && rule.EndDate.Before(rule.StartDate) {
+		return errors.New("EndDate must not be before StartDate")
+	}
+
+	// счет, валюта и категория проверяются так же, как у обычной транзакции
+	sample := Transaction{Type: rule.Type, Currency: rule.Currency, AccountID: rule.AccountID, CategoryID: rule.CategoryID}
+	if err := transactionAccount(rule.LedgerID, &sample); err != nil {
+		return err
+	}
+	if sample.Currency == "" {
+		base, err := baseCurrency(rule.CreatedByID)
+		if err != nil {
+			return err
+		}
+		sample.Currency = base
+	}
+	currency, err := normalizeCurrency(sample.Currency)
+	if err != nil {
+		return err
+	}
+	rule.Currency = currency
+	return validateTransactionCategory(rule.LedgerID, rule.CategoryID, rule.Type)
+}
+
+// scheduleFrom выставляет NextIndex/NextRun на первое повторение не раньше since
+func (r *RecurringRule) scheduleFrom(since time.Time) {
+	r.NextIndex = r.firstIndex(since)
+	r.NextRun = r.occurrence(r.NextIndex)
+}
+
+// @Summary Get recurring rules
+// @Description List recurring transaction rules of the authenticated user
+// @Tags recurring
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} RecurringRule "List of recurring rules"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/recurring [get]
+func GetRecurringRules(c *fiber.Ctx) error {
+	var rules []RecurringRule
+	if err := db.Where("ledger_id = ?", c.Locals("ledger_id").(uint)).Order("id").Find(&rules).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(rules)
+}
+
+// @Summary Get a recurring rule
+// @Description Get a recurring transaction rule by ID
+// @Tags recurring
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Recurring rule ID"
+// @Success 200 {object} RecurringRule "Recurring rule"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Recurring rule not found"
+// @Router /api/recurring/{id} [get]
+func GetRecurringRule(c *fiber.Ctx) error {
+	var rule RecurringRule
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&rule).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Recurring rule not found"})
+	}
+	return c.JSON(rule)
+}
+
+// @Summary Create a recurring rule
+// @Description Create a schedule that automatically creates transactions. Occurrences between StartDate and now are created on the next scheduler run, so StartDate may be at most a year in the past
+// @Tags recurring
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param rule body RecurringRule true "Recurring rule data"
+// @Success 201 {object} RecurringRule "Created recurring rule"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/recurring [post]
+func PostRecurringRule(c *fiber.Ctx) error {
+	rule := new(RecurringRule)
+	if err := c.BodyParser(rule); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	rule.ID = 0
+	rule.LedgerID = c.Locals("ledger_id").(uint)
+	rule.CreatedByID = c.Locals("user_id").(uint)
+	rule.Category, rule.Account = nil, nil
+
+	if err := validateRecurringRule(rule); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if rule.StartDate.Before(time.Now().AddDate(-maxRecurringBackfillYears, 0, 0)) {
+		return c.Status(400).JSON(fiber.Map{"error": "StartDate must not be more than a year in the past"})
+	}
+	rule.scheduleFrom(rule.StartDate)
+
+	if err := db.Create(rule).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(rule)
+}
+
+// @Summary Update a recurring rule
+// @Description Fully update a recurring rule by ID. Already created transactions are kept, the new schedule applies to occurrences from now on
+// @Tags recurring
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Recurring rule ID"
+// @Param rule body RecurringRule true "Full recurring rule data"
+// @Success 200 {object} RecurringRule "Updated recurring rule"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Recurring rule not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/recurring/{id} [put]
+func PutRecurringRule(c *fiber.Ctx) error {
+	var rule RecurringRule
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&rule).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Recurring rule not found"})
+	}
+
+	updated := new(RecurringRule)
+	if err := c.BodyParser(updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// Обновляем все поля расписания (кроме ID, LedgerID, CreatedByID и CreatedAt)
+	rule.Type = updated.Type
+	rule.Amount = updated.Amount
+	rule.Currency = updated.Currency
+	rule.CategoryID = updated.CategoryID
+	rule.AccountID = updated.AccountID
+	rule.Description = updated.Description
+	rule.Frequency = updated.Frequency
+	rule.Interval = updated.Interval
+	rule.DayOfMonth = updated.DayOfMonth
+	rule.StartDate = updated.StartDate
+	rule.EndDate = updated.EndDate
+
+	if err := validateRecurringRule(&rule); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	since := time.Now()
+	if rule.StartDate.After(since) {
+		since = rule.StartDate
+	}
+	rule.scheduleFrom(since)
+
+	if err := db.Save(&rule).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(rule)
+}
+
+// @Summary Delete a recurring rule
+// @Description Delete a recurring rule by ID. Transactions it already created are kept
+// @Tags recurring
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Recurring rule ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Recurring rule not found"
+// @Router /api/recurring/{id} [delete]
+func DeleteRecurringRule(c *fiber.Ctx) error {
+	res := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).Delete(&RecurringRule{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Recurring rule not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Recurring rule deleted successfully"})
+}
+
+// materializeRule создает транзакции для всех наступивших повторений правила.
+// Повторная вставка той же даты игнорируется уникальным индексом, поэтому
+// запуск на нескольких экземплярах сервера или после сбоя не создает дублей
+func materializeRule(tx *gorm.DB, rule *RecurringRule, now time.Time) error {
+	for i := 0; i < maxCatchUpPerRun; i++ {
+		date := rule.occurrence(rule.NextIndex)
+		if date.After(now) || rule.finished(date) {
+			break
+		}
+		transaction := Transaction{
+			LedgerID:        rule.LedgerID,
+			CreatedByID:     rule.CreatedByID,
+			Type:            rule.Type,
+			Amount:          rule.Amount,
+			Currency:        rule.Currency,
+			CategoryID:      rule.CategoryID,
+			AccountID:       rule.AccountID,
+			Description:     rule.Description,
+			Date:            date,
+			RecurringRuleID: &rule.ID,
+		}
+		res := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "recurring_rule_id"}, {Name: "date"}},
+			DoNothing: true,
+		}).Create(&transaction)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected > 0 { // создана планировщиком, автор в истории не указывается
+			if err := recordRevision(tx, revisionActor{}, revisionCreate, nil, &transaction); err != nil {
+				return err
+			}
+		}
+		rule.NextIndex++
+	}
+	rule.NextRun = rule.occurrence(rule.NextIndex)
+	return tx.Model(rule).Select("next_index", "next_run").Updates(rule).Error
+}
+
+// materializeDueTransactions обрабатывает все правила, у которых наступило повторение
+func materializeDueTransactions(now time.Time) {
+	var ids []uint
+	if err := db.Model(&RecurringRule{}).Where("next_run <= ? AND (end_date IS NULL OR next_run <= end_date)", now).Pluck("id", &ids).Error; err != nil {
+		log.Println("Планировщик: ошибка выборки правил:", err)
+		return
+	}
+	for _, id := range ids {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var rule RecurringRule
+			// правило, которое обрабатывает другой экземпляр сервера, пропускаем
+			res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("id = ?", id).Limit(1).Find(&rule)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			return materializeRule(tx, &rule, now)
+		})
+		if err != nil {
+			log.Println("Планировщик: ошибка обработки правила", id, ":", err)
+		}
+	}
+}
+
+// runRecurringScheduler периодически создает транзакции по расписаниям.
+// Первый проход выполняется сразу, чтобы догнать пропущенное за время простоя
+func runRecurringScheduler() {
+	interval := 15 * time.Minute
+	if v := os.Getenv("RECURRING_INTERVAL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Println("Планировщик: некорректный RECURRING_INTERVAL, используется", interval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	materializeDueTransactions(time.Now())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		materializeDueTransactions(now)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurringOccurrence(t *testing.T) {
+	day := func(d int) *int { return &d }
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		rule RecurringRule
+		want []time.Time
+	}{
+		{
+			name: "daily every 3 days",
+			rule: RecurringRule{Frequency: "daily", Interval: 3, StartDate: date(2026, 2, 26)},
+			want: []time.Time{date(2026, 2, 26), date(2026, 3, 1), date(2026, 3, 4)},
+		},
+		{
+			name: "weekly every 2 weeks",
+			rule: RecurringRule{Frequency: "weekly", Interval: 2, StartDate: date(2026, 3, 2)},
+			want: []time.Time{date(2026, 3, 2), date(2026, 3, 16), date(2026, 3, 30)},
+		},
+		{
+			name: "monthly on the 31st is clamped and does not drift",
+			rule: RecurringRule{Frequency: "monthly", Interval: 1, StartDate: date(2026, 1, 31)},
+			want: []time.Time{date(2026, 1, 31), date(2026, 2, 28), date(2026, 3, 31), date(2026, 4, 30), date(2026, 5, 31)},
+		},
+		{
+			name: "monthly with DayOfMonth in a leap year",
+			rule: RecurringRule{Frequency: "monthly", Interval: 1, DayOfMonth: day(30), StartDate: date(2028, 1, 5)},
+			want: []time.Time{date(2028, 1, 30), date(2028, 2, 29), date(2028, 3, 30)},
+		},
+		{
+			name: "quarterly across the year end",
+			rule: RecurringRule{Frequency: "monthly", Interval: 3, StartDate: date(2026, 11, 30)},
+			want: []time.Time{date(2026, 11, 30), date(2027, 2, 28), date(2027, 5, 30)},
+		},
+		{
+			name: "yearly on February 29",
+			rule: RecurringRule{Frequency: "yearly", Interval: 1, StartDate: date(2028, 2, 29)},
+			want: []time.Time{date(2028, 2, 29), date(2029, 2, 28), date(2030, 2, 28), date(2031, 2, 28), date(2032, 2, 29)},
+		},
+	}
+	for _, tt := range tests {
+		for n, want := range tt.want {
+			if got := tt.rule.occurrence(n); !got.Equal(want) {
+				t.Errorf("%s: occurrence(%d) = %v, want %v", tt.name, n, got, want)
+			}
+		}
+	}
+}
+
+func TestRecurringOccurrencesBetween(t *testing.T) {
+	end := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+	rule := RecurringRule{Frequency: "weekly", Interval: 1, StartDate: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), EndDate: &end, NextIndex: 1}
+
+	got := rule.occurrencesBetween(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	want := []time.Time{time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)}
+	if len(got) != len(want) {
+		t.Fatalf("occurrencesBetween = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("occurrencesBetween[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// верхняя граница не включается
+	if got := rule.occurrencesBetween(time.Time{}, time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)); len(got) != 0 {
+		t.Errorf("occurrencesBetween before the next occurrence = %v, want none", got)
+	}
+}
+
+func TestRecurringScheduleFrom(t *testing.T) {
+	rule := RecurringRule{Frequency: "monthly", Interval: 1, StartDate: time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)}
+	rule.scheduleFrom(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	if rule.NextIndex != 2 || !rule.NextRun.Equal(time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("scheduleFrom = %d, %v, want 2, 2026-03-31", rule.NextIndex, rule.NextRun)
+	}
+	rule.scheduleFrom(rule.StartDate)
+	if rule.NextIndex != 0 || !rule.NextRun.Equal(rule.StartDate) {
+		t.Errorf("scheduleFrom(StartDate) = %d, %v, want 0, StartDate", rule.NextIndex, rule.NextRun)
+	}
+
+	// правило из далекого прошлого планируется без перебора всех повторений
+	old := RecurringRule{Frequency: "daily", Interval: 1, StartDate: time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)}
+	old.scheduleFrom(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	if !old.NextRun.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("scheduleFrom for a rule started in year 1 = %v, want 2026-03-02", old.NextRun)
+	}
+}
+
+func TestRecurringFirstIndex(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		berlin = moscow
+	}
+	day := func(d int) *int { return &d }
+	rules := []RecurringRule{
+		{Frequency: "daily", Interval: 1, StartDate: time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{Frequency: "daily", Interval: 3, StartDate: time.Date(2026, 3, 28, 0, 30, 0, 0, berlin)}, // переход на летнее время
+		{Frequency: "weekly", Interval: 2, StartDate: time.Date(2026, 1, 5, 23, 0, 0, 0, moscow)},
+		{Frequency: "monthly", Interval: 1, StartDate: time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)},
+		{Frequency: "monthly", Interval: 5, DayOfMonth: day(15), StartDate: time.Date(2026, 1, 20, 9, 0, 0, 0, time.UTC)},
+		{Frequency: "yearly", Interval: 1, StartDate: time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, rule := range rules {
+		for since := rule.StartDate.AddDate(0, 0, -3); since.Before(rule.StartDate.AddDate(3, 0, 0)); since = since.Add(17 * time.Hour) {
+			want := 0
+			for rule.occurrence(want).Before(since) {
+				want++
+			}
+			if got := rule.firstIndex(since); got != want {
+				t.Fatalf("%s every %d from %v: firstIndex(%v) = %d, want %d", rule.Frequency, rule.Interval, rule.StartDate, since, got, want)
+			}
+		}
+	}
+}
+
+func TestRecurringOverdue(t *testing.T) {
+	end := time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		rule RecurringRule
+		want int
+	}{
+		{name: "all overdue", rule: RecurringRule{Frequency: "daily", Interval: 1, StartDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}, want: 9},
+		{name: "partly created", rule: RecurringRule{Frequency: "daily", Interval: 1, StartDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), NextIndex: 7}, want: 2},
+		{name: "ends before", rule: RecurringRule{Frequency: "daily", Interval: 1, StartDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: &end}, want: 5},
+		{name: "finished and created", rule: RecurringRule{Frequency: "daily", Interval: 1, StartDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: &end, NextIndex: 5}},
+		{name: "in the future", rule: RecurringRule{Frequency: "monthly", Interval: 1, StartDate: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.overdue(time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)); got != tt.want {
+			t.Errorf("%s: overdue = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRecurringRuleErrors(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := start.AddDate(0, 0, -1)
+	day := func(d int) *int { return &d }
+	valid := RecurringRule{Type: "expense", Amount: 100000, Frequency: "monthly", StartDate: start}
+
+	tests := []struct {
+		name   string
+		change func(r *RecurringRule)
+	}{
+		{name: "type", change: func(r *RecurringRule) { r.Type = "transfer" }},
+		{name: "zero amount", change: func(r *RecurringRule) { r.Amount = 0 }},
+		{name: "negative amount", change: func(r *RecurringRule) { r.Amount = -100 }},
+		{name: "frequency", change: func(r *RecurringRule) { r.Frequency = "hourly" }},
+		{name: "negative interval", change: func(r *RecurringRule) { r.Interval = -1 }},
+		{name: "interval too large", change: func(r *RecurringRule) { r.Interval = 1001 }},
+		{name: "DayOfMonth for a weekly rule", change: func(r *RecurringRule) { r.Frequency, r.DayOfMonth = "weekly", day(1) }},
+		{name: "DayOfMonth 32", change: func(r *RecurringRule) { r.DayOfMonth = day(32) }},
+		{name: "DayOfMonth 0", change: func(r *RecurringRule) { r.DayOfMonth = day(0) }},
+		{name: "no StartDate", change: func(r *RecurringRule) { r.StartDate = time.Time{} }},
+		{name: "EndDate before StartDate", change: func(r *RecurringRule) { r.EndDate = &before }},
+	}
+	for _, tt := range tests {
+		rule := valid
+		tt.change(&rule)
+		if err := validateRecurringRule(&rule); err == nil {
+			t.Errorf("%s: want error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// reportChange - изменение относительно предыдущего периода
+type reportChange struct {
+	Delta   Money    `json:"delta" swaggertype:"string"`
+	Percent *float64 `json:"percent"` // null, если в предыдущем периоде было 0
+}
+
+// reportTotals - доходы и расходы за период
+type reportTotals struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"` // не включается в период
+	Income  Money     `json:"income" swaggertype:"string"`
+	Expense Money     `json:"expense" swaggertype:"string"`
+	Net     Money     `json:"net" swaggertype:"string"` // доходы минус расходы
+}
+
+// reportCategory - сумма по категории за период и за предыдущий период
+type reportCategory struct {
+	CategoryID     *uint        `json:"category_id"` // null - без категории
+	Name           string       `json:"name"`
+	Type           string       `json:"type"`
+	Amount         Money        `json:"amount" swaggertype:"string"`
+	Share          float64      `json:"share"` // доля в доходах или расходах периода, %
+	PreviousAmount Money        `json:"previous_amount" swaggertype:"string"`
+	Change         reportChange `json:"change" gorm:"-"`
+}
+
+// reportTag - сумма транзакций с тегом за период и за предыдущий период.
+// Транзакция с несколькими тегами учитывается в каждом из них
+type reportTag struct {
+	TagID          uint         `json:"tag_id"`
+	Name           string       `json:"name"`
+	Type           string       `json:"type"`
+	Amount         Money        `json:"amount" swaggertype:"string"`
+	Count          int          `json:"count"` // число транзакций за период
+	PreviousAmount Money        `json:"previous_amount" swaggertype:"string"`
+	Change         reportChange `json:"change" gorm:"-"`
+}
+
+// reportPeriod - доходы и расходы за день/неделю/месяц/год
+type reportPeriod struct {
+	Period  time.Time `json:"period"` // начало интервала
+	Income  Money     `json:"income" swaggertype:"string"`
+	Expense Money     `json:"expense" swaggertype:"string"`
+	Net     Money     `json:"net" swaggertype:"string"`
+}
+
+// reportAverages - средние суммы за интервал, периоды без операций тоже учитываются
+type reportAverages struct {
+	Periods int   `json:"periods"`
+	Income  Money `json:"income" swaggertype:"string"`
+	Expense Money `json:"expense" swaggertype:"string"`
+	Net     Money `json:"net" swaggertype:"string"`
+}
+
+// reportSummary - сводный отчет о доходах и расходах
+type reportSummary struct {
+	reportTotals
+	Currency string       `json:"currency"` // базовая валюта пользователя
+	Interval string       `json:"interval"`
+	Previous reportTotals `json:"previous"` // предыдущий период такой же длины
+	Change   struct {
+		Income  reportChange `json:"income"`
+		Expense reportChange `json:"expense"`
+		Net     reportChange `json:"net"`
+	} `json:"change"`
+	Average       reportAverages   `json:"average"`
+	ByCategory    []reportCategory `json:"by_category"`
+	TopCategories []reportCategory `json:"top_categories"` // самые крупные категории расходов
+	ByTag         []reportTag      `json:"by_tag"`
+	ByPeriod      []reportPeriod   `json:"by_period"`
+}
+
+// compareAmounts считает разницу и процент изменения суммы
+func compareAmounts(current, previous Money) (reportChange, error) {
+	delta, err := current.Sub(previous)
+	if err != nil {
+		return reportChange{}, err
+	}
+	change := reportChange{Delta: delta}
+	if previous != 0 {
+		percent := math.Round(float64(change.Delta)/math.Abs(float64(previous))*10000) / 100
+		change.Percent = &percent
+	}
+	return change, nil
+}
+
+// maxReportIntervals - наибольшее число интервалов в разбивке отчета по периодам
+const maxReportIntervals = 1000
+
+// reportIntervals считает, на сколько интервалов разбивается период [from, to)
+func reportIntervals(from, to time.Time, interval string) int64 {
+	last := to.Add(-time.Microsecond)
+	switch interval {
+	case "day", "week": // дни считаются по календарным датам, без длительностей, которые переполняются на больших периодах
+		days := (time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, time.UTC).Unix() -
+			time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC).Unix()) / 86400
+		if interval == "day" {
+			return days + 1
+		}
+		return (days+int64(from.Weekday()+6)%7)/7 + 1 // недели начинаются с понедельника
+	case "year":
+		return int64(last.Year()-from.Year()) + 1
+	}
+	return int64(last.Year()-from.Year())*12 + int64(last.Month()-from.Month()) + 1
+}
+
+// previousPeriod возвращает начало предыдущего периода. Если период состоит из целых
+// календарных месяцев, сдвиг тоже в месяцах, иначе в календарных днях (и остатке
+// времени меньше суток), чтобы переход на летнее время не сдвигал границу
+func previousPeriod(from, to time.Time) time.Time {
+	monthStart := func(t time.Time) bool {
+		return t.Day() == 1 && t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+	}
+	if monthStart(from) && monthStart(to) {
+		months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+		return from.AddDate(0, -months, 0)
+	}
+	to = to.In(from.Location())
+	days := int((time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC).Unix() -
+		time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC).Unix()) / 86400)
+	rest := to.AddDate(0, 0, -days).Sub(from)
+	return from.AddDate(0, 0, -days).Add(-rest)
+}
+
+// unknownQueryParam возвращает первый параметр запроса, которого нет в allowed
+func unknownQueryParam(c *fiber.Ctx, allowed ...string) string {
+	var unknown string
+	c.Context().QueryArgs().VisitAll(func(key, _ []byte) {
+		if unknown == "" && !slices.Contains(allowed, string(key)) {
+			unknown = string(key)
+		}
+	})
+	return unknown
+}
+
+// @Summary Get spending summary
+// @Description Income and expense totals in the base currency for a date range, grouped by category, by tag and by interval, with top expense categories, averages per interval and comparison with the previous period of the same length. Defaults to the current month; other query parameters, such as as_of, are rejected
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param from query string false "Start date inclusive (YYYY-MM-DD or RFC3339), defaults to the first day of the current month"
+// @Param to query string false "End date inclusive (YYYY-MM-DD or RFC3339), defaults to today"
+// @Param interval query string false "Grouping interval" Enums(day, week, month, year) default(month)
+// @Param top query int false "Number of top expense categories (1-50)" default(5)
+// @Success 200 {object} reportSummary "Summary"
+// @Failure 400 {object} map[string]string "Invalid query parameters or more than 1000 intervals"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 422 {object} map[string]string "Missing exchange rate or amount out of range"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/reports/summary [get]
+func GetReportSummary(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	ledgerID := c.Locals("ledger_id").(uint)
+
+	if param := unknownQueryParam(c, "from", "to", "interval", "top"); param != "" { //в том числе as_of: отчет строится за период
+		return c.Status(400).JSON(fiber.Map{"error": "unsupported query parameter '" + param + "'"})
+	}
+	interval := c.Query("interval", "month")
+	if interval != "day" && interval != "week" && interval != "month" && interval != "year" {
+		return c.Status(400).JSON(fiber.Map{"error": "interval must be 'day', 'week', 'month' or 'year'"})
+	}
+	top, err := strconv.Atoi(c.Query("top", "5"))
+	if err != nil || top < 1 || top > 50 {
+		return c.Status(400).JSON(fiber.Map{"error": "top must be a number between 1 and 50"})
+	}
+	from, to, err := parsePeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	if to == nil {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		to = &tomorrow
+	}
+	if from == nil {
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		from = &monthStart
+	}
+	if !from.Before(*to) {
+		return c.Status(400).JSON(fiber.Map{"error": "from must be before to"})
+	}
+	if reportIntervals(*from, *to, interval) > maxReportIntervals {
+		return c.Status(400).JSON(fiber.Map{"error": "The period spans more than " + strconv.Itoa(maxReportIntervals) + " intervals, choose a larger interval or a shorter period"})
+	}
+
+	base, err := baseCurrency(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	summary := reportSummary{Currency: base, Interval: interval}
+	summary.From, summary.To = *from, *to
+	summary.Previous.From, summary.Previous.To = previousPeriod(*from, *to), *from
+
+	// оба периода считаются одним проходом по транзакциям
+	converted := baseAmounts(ledgerID, userID, base).Where("date >= ? AND date < ?", summary.Previous.From, summary.To)
+	if err := missingRate(converted, base); err != nil {
+		return conversionError(c, err)
+	}
+
+	var totals struct {
+		Income, Expense, PreviousIncome, PreviousExpense Money
+	}
+	err = db.Table("(?) AS t", converted).
+		Select("COALESCE(SUM(CASE WHEN type = 'income' AND date >= @from THEN base_amount END),0) AS income, "+
+			"COALESCE(SUM(CASE WHEN type = 'expense' AND date >= @from THEN base_amount END),0) AS expense, "+
+			"COALESCE(SUM(CASE WHEN type = 'income' AND date < @from THEN base_amount END),0) AS previous_income, "+
+			"COALESCE(SUM(CASE WHEN type = 'expense' AND date < @from THEN base_amount END),0) AS previous_expense",
+			map[string]interface{}{"from": summary.From}).
+		Scan(&totals).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	summary.Income, summary.Expense = totals.Income, totals.Expense
+	summary.Previous.Income, summary.Previous.Expense = totals.PreviousIncome, totals.PreviousExpense
+	if summary.Net, err = totals.Income.Sub(totals.Expense); err != nil {
+		return conversionError(c, err)
+	}
+	if summary.Previous.Net, err = totals.PreviousIncome.Sub(totals.PreviousExpense); err != nil {
+		return conversionError(c, err)
+	}
+	if summary.Change.Income, err = compareAmounts(summary.Income, summary.Previous.Income); err != nil {
+		return conversionError(c, err)
+	}
+	if summary.Change.Expense, err = compareAmounts(summary.Expense, summary.Previous.Expense); err != nil {
+		return conversionError(c, err)
+	}
+	if summary.Change.Net, err = compareAmounts(summary.Net, summary.Previous.Net); err != nil {
+		return conversionError(c, err)
+	}
+
+	// суммы по категориям с учетом разбивки; доля считается от всех доходов или расходов периода
+	byCategory := db.Table("(?) AS t", splitLines(converted)).
+		Joins("LEFT JOIN categories ON categories.id = t.category_id").
+		Select("t.category_id, COALESCE(categories.name, '') AS name, t.type, "+
+			"COALESCE(SUM(CASE WHEN t.date >= @from THEN t.base_amount END),0) AS amount, "+
+			"COALESCE(SUM(CASE WHEN t.date < @from THEN t.base_amount END),0) AS previous_amount, "+
+			"COALESCE(ROUND(SUM(CASE WHEN t.date >= @from THEN t.base_amount END) * 100 / "+
+			"NULLIF(SUM(SUM(CASE WHEN t.date >= @from THEN t.base_amount END)) OVER (PARTITION BY t.type), 0), 2),0) AS share",
+			map[string]interface{}{"from": summary.From}).
+		Where("t.type IN ('income','expense')").
+		Group("t.category_id, categories.name, t.type")
+
+	summary.ByCategory = []reportCategory{}
+	if err := byCategory.Order("t.type, amount DESC, name").Scan(&summary.ByCategory).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	summary.TopCategories = []reportCategory{}
+	err = db.Table("(?) AS c", byCategory).
+		Where("c.type = 'expense' AND c.amount > 0").
+		Order("c.amount DESC, c.name").
+		Limit(top).
+		Scan(&summary.TopCategories).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, categories := range [][]reportCategory{summary.ByCategory, summary.TopCategories} {
+		for i := range categories {
+			if categories[i].Change, err = compareAmounts(categories[i].Amount, categories[i].PreviousAmount); err != nil {
+				return conversionError(c, err)
+			}
+		}
+	}
+
+	// суммы по тегам; тег относится ко всей транзакции, поэтому разбивка не учитывается
+	summary.ByTag = []reportTag{}
+	err = db.Table("(?) AS t", converted).
+		Joins("JOIN transaction_tags ON transaction_tags.transaction_id = t.id").
+		Joins("JOIN tags ON tags.id = transaction_tags.tag_id").
+		Select("tags.id AS tag_id, tags.name, t.type, "+
+			"COALESCE(SUM(CASE WHEN t.date >= @from THEN t.base_amount END),0) AS amount, "+
+			"COUNT(CASE WHEN t.date >= @from THEN 1 END) AS count, "+
+			"COALESCE(SUM(CASE WHEN t.date < @from THEN t.base_amount END),0) AS previous_amount",
+			map[string]interface{}{"from": summary.From}).
+		Where("t.type IN ('income','expense')").
+		Group("tags.id, tags.name, t.type").
+		Order("t.type, amount DESC, tags.name").
+		Scan(&summary.ByTag).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for i := range summary.ByTag {
+		if summary.ByTag[i].Change, err = compareAmounts(summary.ByTag[i].Amount, summary.ByTag[i].PreviousAmount); err != nil {
+			return conversionError(c, err)
+		}
+	}
+
+	// суммы по интервалам; интервалы без операций заполняются нулями
+	periods := db.Table("(?) AS t", converted).
+		Select("date_trunc(?, date) AS period, "+
+			"COALESCE(SUM(CASE WHEN type = 'income' THEN base_amount END),0) AS income, "+
+			"COALESCE(SUM(CASE WHEN type = 'expense' THEN base_amount END),0) AS expense", interval).
+		Where("date >= ?", summary.From).
+		Group("period")
+	series := db.Table("generate_series(date_trunc(?, ?::timestamptz), ?::timestamptz, ?::interval) AS s(period)",
+		interval, summary.From, summary.To.Add(-time.Microsecond), "1 "+interval).
+		Joins("LEFT JOIN (?) AS p ON p.period = s.period", periods).
+		Select("s.period, COALESCE(p.income,0) AS income, COALESCE(p.expense,0) AS expense, " +
+			"COALESCE(p.income,0) - COALESCE(p.expense,0) AS net")
+
+	summary.ByPeriod = []reportPeriod{}
+	if err := series.Order("s.period").Scan(&summary.ByPeriod).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	err = db.Table("(?) AS b", series).
+		Select("COUNT(*) AS periods, COALESCE(AVG(income),0) AS income, COALESCE(AVG(expense),0) AS expense, COALESCE(AVG(net),0) AS net").
+		Scan(&summary.Average).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(summary)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCompareAmounts(t *testing.T) {
+	percent := func(p float64) *float64 { return &p }
+	tests := []struct {
+		current, previous Money
+		want              reportChange
+		wantErr           bool
+	}{
+		{current: 15000, previous: 10000, want: reportChange{Delta: 5000, Percent: percent(50)}},
+		{current: 5000, previous: 10000, want: reportChange{Delta: -5000, Percent: percent(-50)}},
+		{current: 10000, previous: 0, want: reportChange{Delta: 10000}},
+		{current: 0, previous: 0, want: reportChange{Delta: 0}},
+		{current: 10000, previous: 30000, want: reportChange{Delta: -20000, Percent: percent(-66.67)}},
+		{current: -5000, previous: -10000, want: reportChange{Delta: 5000, Percent: percent(50)}}, // чистый итог меньше по модулю
+		{current: math.MaxInt64, previous: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := compareAmounts(tt.current, tt.previous)
+		if tt.wantErr {
+			if !errors.Is(err, errMoneyOverflow) {
+				t.Errorf("compareAmounts(%v, %v) = %+v, %v, want overflow", tt.current, tt.previous, got, err)
+			}
+			continue
+		}
+		if err != nil || got.Delta != tt.want.Delta || (got.Percent == nil) != (tt.want.Percent == nil) ||
+			got.Percent != nil && *got.Percent != *tt.want.Percent {
+			t.Errorf("compareAmounts(%v, %v) = %+v, %v, want %+v", tt.current, tt.previous, got, err, tt.want)
+		}
+	}
+}
+
+func TestPreviousPeriod(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     time.Time
+	}{
+		{name: "March after February", from: date(2026, 3, 1), to: date(2026, 4, 1), want: date(2026, 2, 1)},
+		{name: "quarter", from: date(2026, 4, 1), to: date(2026, 7, 1), want: date(2026, 1, 1)},
+		{name: "year", from: date(2026, 1, 1), to: date(2027, 1, 1), want: date(2025, 1, 1)},
+		{name: "ten days", from: date(2026, 3, 11), to: date(2026, 3, 21), want: date(2026, 3, 1)},
+		{name: "days across a month", from: date(2026, 3, 1), to: date(2026, 3, 15), want: date(2026, 2, 15)},
+	}
+	for _, tt := range tests {
+		if got := previousPeriod(tt.from, tt.to); !got.Equal(tt.want) {
+			t.Errorf("%s: previousPeriod = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// неделя с переходом на летнее время: в ней 167 часов, но предыдущая начинается в полночь
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	from, to := time.Date(2026, 3, 25, 0, 0, 0, 0, berlin), time.Date(2026, 4, 1, 0, 0, 0, 0, berlin)
+	if got, want := previousPeriod(from, to), time.Date(2026, 3, 18, 0, 0, 0, 0, berlin); !got.Equal(want) {
+		t.Errorf("week across DST: previousPeriod = %v, want %v", got, want)
+	}
+	from, to = time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), time.Date(2026, 3, 30, 18, 0, 0, 0, berlin)
+	if got, want := previousPeriod(from, to), time.Date(2026, 3, 26, 6, 0, 0, 0, berlin); !got.Equal(want) {
+		t.Errorf("days and hours across DST: previousPeriod = %v, want %v", got, want)
+	}
+}
+
+func TestUnknownQueryParam(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{query: ""},
+		{query: "from=2026-03-01&to=2026-03-31&interval=day&top=3"},
+		{query: "as_of=2026-03-01", want: "as_of"},
+		{query: "from=2026-03-01&category_id=5&page=2", want: "category_id"},
+	}
+	for _, tt := range tests {
+		var got string
+		withQuery(t, tt.query, func(c *fiber.Ctx) { got = unknownQueryParam(c, "from", "to", "interval", "top") })
+		if got != tt.want {
+			t.Errorf("unknownQueryParam(%s) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestReportIntervals(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		from, to time.Time
+		interval string
+		want     int64
+	}{
+		{from: date(2026, 3, 1), to: date(2026, 3, 2), interval: "day", want: 1},
+		{from: date(2026, 3, 1), to: date(2026, 4, 1), interval: "day", want: 31},
+		{from: date(2026, 3, 1), to: time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC), interval: "day", want: 31},
+		{from: date(2026, 3, 2), to: date(2026, 3, 9), interval: "week", want: 1},  // понедельник - понедельник
+		{from: date(2026, 3, 1), to: date(2026, 3, 9), interval: "week", want: 2},  // воскресенье попадает в предыдущую неделю
+		{from: date(2026, 3, 8), to: date(2026, 3, 10), interval: "week", want: 2}, // воскресенье и понедельник
+		{from: date(2026, 1, 1), to: date(2027, 1, 1), interval: "week", want: 53},
+		{from: date(2026, 1, 15), to: date(2026, 2, 1), interval: "month", want: 1},
+		{from: date(2026, 1, 31), to: date(2026, 2, 2), interval: "month", want: 2},
+		{from: date(2025, 11, 1), to: date(2026, 3, 1), interval: "month", want: 4},
+		{from: date(2026, 6, 1), to: date(2026, 6, 2), interval: "year", want: 1},
+		{from: date(2000, 1, 1), to: date(2026, 3, 1), interval: "year", want: 27},
+		{from: date(1, 1, 1), to: date(9999, 12, 31), interval: "day", want: 3652058}, // без переполнения time.Duration
+	}
+	for _, tt := range tests {
+		if got := reportIntervals(tt.from, tt.to, tt.interval); got != tt.want {
+			t.Errorf("reportIntervals(%s, %s, %s) = %d, want %d",
+				tt.from.Format("2006-01-02"), tt.to.Format("2006-01-02"), tt.interval, got, tt.want)
+		}
+	}
+	if reportIntervals(date(2024, 1, 1), date(2026, 10, 1), "day") <= maxReportIntervals {
+		t.Error("daily breakdown of almost three years must exceed the limit")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// сколько частей может быть у одной транзакции
+const maxSplitLines = 50
+
+// TransactionSplit - часть транзакции со своей категорией, например группа позиций одного чека
+type TransactionSplit struct {
+	ID            uint      `gorm:"primaryKey"`
+	TransactionID uint      `gorm:"not null;index"`
+	CategoryID    *uint     //категория части, может быть не указана
+	Category      *Category `gorm:"constraint:OnDelete:SET NULL"`                   //заполняется только в ответах
+	Amount        Money     `gorm:"not null" swaggertype:"string" example:"500.00"` //в валюте транзакции
+	Note          *string
+}
+
+// validateSplits проверяет разбивку транзакции: категории частей относятся к книге и подходят
+// по типу, суммы положительные и в сумме дают сумму транзакции.
+// Категория транзакции с разбивкой задается только в частях
+func validateSplits(ledgerID uint, transaction *Transaction) error {
+	if len(transaction.Splits) == 0 {
+		return nil
+	}
+	if transaction.Type == "transfer" {
+		return errors.New("Transfers cannot be split")
+	}
+	if len(transaction.Splits) < 2 {
+		return errors.New("A split transaction needs at least two lines")
+	}
+	if len(transaction.Splits) > maxSplitLines {
+		return errors.New("A transaction can have at most 50 split lines")
+	}
+	if transaction.CategoryID != nil {
+		return errors.New("CategoryID must be empty for a split transaction, set categories on the lines")
+	}
+
+	var total Money
+	for i := range transaction.Splits {
+		line := &transaction.Splits[i]
+		line.ID, line.TransactionID, line.Category = 0, 0, nil // части всегда создаются заново
+		if line.Amount <= 0 {
+			return errors.New("Split amounts must be positive")
+		}
+		if err := validateTransactionCategory(ledgerID, line.CategoryID, transaction.Type); err != nil {
+			return err
+		}
+		if line.Note != nil {
+			if note := strings.TrimSpace(*line.Note); note != "" {
+				line.Note = &note
+			} else {
+				line.Note = nil
+			}
+		}
+		var err error
+		if total, err = total.Add(line.Amount); err != nil { // до 50 частей, сумма может не поместиться в int64
+			return errors.New("split total out of range")
+		}
+	}
+	if total != transaction.Amount {
+		return errors.New("Split amounts must sum to the transaction amount " + transaction.Amount.String() + ", got " + total.String())
+	}
+	return nil
+}
+
+// splitLines разворачивает транзакции (результат baseAmounts) в строки по категориям:
+// транзакция с разбивкой дает строку на каждую часть, остальные - одну строку.
+// base_amount части - разность округленных нарастающих итогов, поэтому сумма частей
+// в базовой валюте в точности равна base_amount транзакции
+func splitLines(converted *gorm.DB) *gorm.DB {
+	return db.Table("(?) AS t", converted).
+		Joins("LEFT JOIN (SELECT transaction_id, category_id, amount, " +
+			"SUM(amount) OVER (PARTITION BY transaction_id ORDER BY id) AS running FROM transaction_splits) AS s ON s.transaction_id = t.id").
+		Select("t.id, t.type, t.date, t.currency, t.recurring_rule_id, " +
+			"CASE WHEN s.transaction_id IS NULL THEN t.category_id ELSE s.category_id END AS category_id, " +
+			"CASE WHEN s.transaction_id IS NULL THEN t.base_amount " +
+			"ELSE ROUND(t.base_amount * s.running / t.amount, 2) - ROUND(t.base_amount * (s.running - s.amount) / t.amount, 2) END AS base_amount")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestValidateSplits(t *testing.T) {
+	note := func(s string) *string { return &s }
+	lines := func(amounts ...Money) []TransactionSplit {
+		splits := make([]TransactionSplit, len(amounts))
+		for i, amount := range amounts {
+			splits[i] = TransactionSplit{ID: uint(i + 10), TransactionID: 5, Amount: amount}
+		}
+		return splits
+	}
+	categoryID := uint(3)
+	tests := []struct {
+		name        string
+		transaction Transaction
+		wantErr     string
+	}{
+		{name: "no splits", transaction: Transaction{Type: "expense", Amount: 1000, CategoryID: &categoryID}},
+		{name: "two lines", transaction: Transaction{Type: "expense", Amount: 1000, Splits: lines(600, 400)}},
+		{name: "one line", transaction: Transaction{Type: "expense", Amount: 1000, Splits: lines(1000)}, wantErr: "at least two lines"},
+		{name: "too many lines", transaction: Transaction{Type: "expense", Amount: 51, Splits: lines(make([]Money, 51)...)}, wantErr: "at most 50"},
+		{name: "transfer", transaction: Transaction{Type: "transfer", Amount: 1000, Splits: lines(600, 400)}, wantErr: "cannot be split"},
+		{name: "category on transaction", transaction: Transaction{Type: "expense", Amount: 1000, CategoryID: &categoryID, Splits: lines(600, 400)}, wantErr: "CategoryID must be empty"},
+		{name: "zero line", transaction: Transaction{Type: "expense", Amount: 1000, Splits: lines(1000, 0)}, wantErr: "must be positive"},
+		{name: "sum mismatch", transaction: Transaction{Type: "expense", Amount: 1000, Splits: lines(600, 399)}, wantErr: "must sum to the transaction amount 10.00, got 9.99"},
+		{name: "total overflow", transaction: Transaction{Type: "expense", Amount: 1000, Splits: lines(math.MaxInt64, 1)}, wantErr: "out of range"},
+	}
+	for _, tt := range tests {
+		err := validateSplits(1, &tt.transaction)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// части создаются заново, пустые заметки убираются
+	transaction := Transaction{Type: "income", Amount: 300, Splits: lines(100, 200)}
+	transaction.Splits[0].Note = note("  bonus ")
+	transaction.Splits[1].Note = note("   ")
+	if err := validateSplits(1, &transaction); err != nil {
+		t.Fatal(err)
+	}
+	for i, line := range transaction.Splits {
+		if line.ID != 0 || line.TransactionID != 0 {
+			t.Errorf("line %d keeps ID %d and TransactionID %d", i, line.ID, line.TransactionID)
+		}
+	}
+	if n := transaction.Splits[0].Note; n == nil || *n != "bonus" {
+		t.Errorf("note = %v, want trimmed", n)
+	}
+	if transaction.Splits[1].Note != nil {
+		t.Errorf("blank note = %q, want nil", *transaction.Splits[1].Note)
+	}
+}
+
+func TestSplitLinesRounding(t *testing.T) {
+	// запрос строится без подключения к бд
+	dryRun, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := db
+	db = dryRun
+	defer func() { db = saved }()
+
+	sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return splitLines(tx.Table("transactions")).Find(&[]map[string]interface{}{})
+	})
+	// сумма частей в базовой валюте равна сумме транзакции: каждая часть - разность
+	// округленных нарастающих итогов, ошибки округления не накапливаются
+	for _, want := range []string{
+		"SUM(amount) OVER (PARTITION BY transaction_id ORDER BY id) AS running",
+		"ROUND(t.base_amount * s.running / t.amount, 2) - ROUND(t.base_amount * (s.running - s.amount) / t.amount, 2)",
+		"WHEN s.transaction_id IS NULL THEN t.base_amount",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("splitLines SQL does not contain %q:\n%s", want, sql)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// сколько тегов может быть у одной транзакции
+const maxTransactionTags = 20
+
+// Tag - метка книги для сквозной группировки транзакций поверх категорий,
+// например "отпуск-2026" или "налоговый вычет"
+type Tag struct {
+	ID        uint   `gorm:"primaryKey"`
+	LedgerID  uint   `gorm:"not null;uniqueIndex:idx_tag_name"`
+	Name      string `gorm:"size:50;not null;uniqueIndex:idx_tag_name"`
+	Color     string //цвет в формате #RRGGBB
+	CreatedAt time.Time
+}
+
+// validateTag проверяет поля тега перед сохранением; имена в книге не повторяются без учета регистра
+func validateTag(tag *Tag) error {
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" || len([]rune(tag.Name)) > 50 {
+		return errors.New("Name is required and must be at most 50 characters")
+	}
+	if tag.Color != "" && !colorPattern.MatchString(tag.Color) {
+		return errors.New("Color must be in #RRGGBB format")
+	}
+	var count int64
+	err := db.Model(&Tag{}).
+		Where("ledger_id = ? AND LOWER(name) = LOWER(?) AND id <> ?", tag.LedgerID, tag.Name, tag.ID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("Tag with this name already exists")
+	}
+	return nil
+}
+
+// resolveTags находит теги транзакции по ID или по имени. Тег с новым именем
+// возвращается без ID и создается вместе с транзакцией
+func resolveTags(ledgerID uint, tags []Tag) ([]Tag, error) {
+	if len(tags) > maxTransactionTags {
+		return nil, errors.New("A transaction can have at most " + strconv.Itoa(maxTransactionTags) + " tags")
+	}
+	resolved := make([]Tag, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, requested := range tags {
+		var tag Tag
+		if requested.ID != 0 {
+			if err := db.Where("id = ? AND ledger_id = ?", requested.ID, ledgerID).First(&tag).Error; err != nil {
+				return nil, errors.New("Tag " + strconv.FormatUint(uint64(requested.ID), 10) + " not found")
+			}
+		} else {
+			name := strings.TrimSpace(requested.Name)
+			if name == "" || len([]rune(name)) > 50 {
+				return nil, errors.New("Tag name is required and must be at most 50 characters")
+			}
+			err := db.Where("ledger_id = ? AND LOWER(name) = LOWER(?)", ledgerID, name).First(&tag).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				tag = Tag{LedgerID: ledgerID, Name: name}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		// один и тот же тег, указанный дважды, сохраняется один раз
+		key := strings.ToLower(tag.Name)
+		if !seen[key] {
+			seen[key] = true
+			resolved = append(resolved, tag)
+		}
+	}
+	return resolved, nil
+}
+
+// @Summary Get tags
+// @Description List tags of the current ledger
+// @Tags tags
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} Tag "List of tags"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/tags [get]
+func GetTags(c *fiber.Ctx) error {
+	var tags []Tag
+	if err := db.Where("ledger_id = ?", c.Locals("ledger_id").(uint)).Order("name").Find(&tags).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tags)
+}
+
+// @Summary Get a tag
+// @Description Get a tag by ID
+// @Tags tags
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Tag ID"
+// @Success 200 {object} Tag "Tag"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Tag not found"
+// @Router /api/tags/{id} [get]
+func GetTag(c *fiber.Ctx) error {
+	var tag Tag
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&tag).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Tag not found"})
+	}
+	return c.JSON(tag)
+}
+
+// @Summary Create a tag
+// @Description Create a tag in the current ledger. Tags can also be created on the fly by name when saving a transaction
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param tag body Tag true "Tag data"
+// @Success 201 {object} Tag "Created tag"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/tags [post]
+func PostTag(c *fiber.Ctx) error {
+	tag := new(Tag)
+	if err := c.BodyParser(tag); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	tag.ID = 0
+	tag.LedgerID = c.Locals("ledger_id").(uint)
+
+	if err := validateTag(tag); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Create(tag).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(tag)
+}
+
+// @Summary Update a tag
+// @Description Rename a tag or change its color; tagged transactions keep the tag
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Tag ID"
+// @Param tag body Tag true "Full tag data"
+// @Success 200 {object} Tag "Updated tag"
+// @Failure 400 {object} map[string]string "Invalid request body or parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Tag not found"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/tags/{id} [put]
+func PutTag(c *fiber.Ctx) error {
+	var tag Tag
+	if err := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).First(&tag).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Tag not found"})
+	}
+
+	updated := new(Tag)
+	if err := c.BodyParser(updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	tag.Name = updated.Name
+	tag.Color = updated.Color
+
+	if err := validateTag(&tag); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Save(&tag).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tag)
+}
+
+// @Summary Delete a tag
+// @Description Delete a tag by ID. It is removed from all transactions, the transactions themselves are kept
+// @Tags tags
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Tag ID"
+// @Success 200 {object} map[string]string "Success response"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Tag not found"
+// @Router /api/tags/{id} [delete]
+func DeleteTag(c *fiber.Ctx) error {
+	res := db.Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).Delete(&Tag{})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Tag not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Tag deleted successfully"})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// секрет из тестовых векторов RFC 4226 и RFC 6238: "12345678901234567890" в base32
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226, приложение D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	key := []byte("12345678901234567890")
+	for counter, code := range want {
+		if got := hotp(key, uint64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	// RFC 6238, приложение B (SHA1), последние 6 цифр
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		step, ok := verifyTOTP(rfcTOTPSecret, v.code, time.Unix(v.unix, 0))
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("verifyTOTP(%s) at %d = %d, %v, want step %d", v.code, v.unix, step, ok, v.unix/totpPeriod)
+		}
+	}
+
+	now := time.Unix(1234567890, 0) // интервал 41152263, код 005924
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		now      time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", secret: rfcTOTPSecret, code: "005924", now: now, wantStep: 41152263, wantOK: true},
+		{name: "spaces in code", secret: rfcTOTPSecret, code: "005 924", now: now, wantStep: 41152263, wantOK: true},
+		{name: "lowercase secret", secret: strings.ToLower(rfcTOTPSecret), code: "005924", now: now, wantStep: 41152263, wantOK: true},
+		{name: "previous step within skew", secret: rfcTOTPSecret, code: "005924", now: now.Add(totpPeriod * time.Second), wantStep: 41152263, wantOK: true},
+		{name: "next step within skew", secret: rfcTOTPSecret, code: "005924", now: now.Add(-totpPeriod * time.Second), wantStep: 41152263, wantOK: true},
+		{name: "two steps late", secret: rfcTOTPSecret, code: "005924", now: now.Add(2 * totpPeriod * time.Second)},
+		{name: "wrong code", secret: rfcTOTPSecret, code: "005925", now: now},
+		{name: "short code", secret: rfcTOTPSecret, code: "05924", now: now},
+		{name: "long code", secret: rfcTOTPSecret, code: "0059240", now: now},
+		{name: "invalid secret", secret: "not base32!", code: "005924", now: now},
+	}
+	for _, tt := range tests {
+		step, ok := verifyTOTP(tt.secret, tt.code, tt.now)
+		if ok != tt.wantOK || step != tt.wantStep {
+			t.Errorf("%s: verifyTOTP = %d, %v, want %d, %v", tt.name, step, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret := generateTOTPSecret()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("generateTOTPSecret() = %q: %d bytes, %v", secret, len(key), err)
+	}
+	if secret == generateTOTPSecret() {
+		t.Error("generateTOTPSecret returned the same secret twice")
+	}
+	if _, ok := verifyTOTP(secret, hotp(key, uint64(time.Now().Unix()/totpPeriod)), time.Now()); !ok {
+		t.Error("code generated from a new secret is rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	got := totpURI("Finance Tracker", "user@example.com", rfcTOTPSecret)
+	want := "otpauth://totp/Finance%20Tracker:user@example.com?algorithm=SHA1&digits=6&issuer=Finance%20Tracker&period=30&secret=" + rfcTOTPSecret
+	if got != want {
+		t.Errorf("totpURI = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// поля, по которым разрешена сортировка: имя параметра -> колонка в бд
+var transactionSortFields = map[string]string{
+	"date":       "date",
+	"amount":     "amount",
+	"created_at": "created_at",
+}
+
+// transactionQuery - фильтры, сортировка и пагинация для списка транзакций
+type transactionQuery struct {
+	From      *time.Time // включительно
+	To        *time.Time // не включительно
+	Type      string
+	Category  *uint // категория вместе с подкатегориями
+	Account   *uint // счет, включая входящие переводы
+	MinAmount *Money
+	MaxAmount *Money
+	Search    string // поиск по описанию
+	Tags      []uint // теги
+	AllTags   bool   // true - нужны все теги, false - хотя бы один
+	Sort      string
+	Desc      bool
+	Limit     int
+	Cursor    *transactionCursor
+}
+
+// transactionCursor - позиция последней выданной записи
+type transactionCursor struct {
+	Value string `json:"v"` // значение поля сортировки
+	ID    uint   `json:"id"`
+}
+
+// transactionPage - ответ со страницей транзакций
+type transactionPage struct {
+	Items      []Transaction `json:"items"`
+	NextCursor string        `json:"next_cursor,omitempty"` // пустой, если записей больше нет
+}
+
+// parseDateParam принимает дату в формате 2006-01-02 или RFC3339.
+// dateOnly сообщает, что время не было указано
+func parseDateParam(value string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	if t, err = time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, errors.New("invalid date '" + value + "', expected YYYY-MM-DD or RFC3339")
+}
+
+// parseTransactionQuery читает параметры фильтрации из query-строки
+func parseTransactionQuery(c *fiber.Ctx) (*transactionQuery, error) {
+	q := &transactionQuery{
+		Type:   c.Query("type"),
+		Search: strings.TrimSpace(c.Query("q")),
+		Sort:   c.Query("sort", "date"),
+		Limit:  defaultPageSize,
+	}
+
+	var err error
+	if q.Category, err = parseIDParam(c, "category_id"); err != nil {
+		return nil, err
+	}
+	if q.Account, err = parseIDParam(c, "account_id"); err != nil {
+		return nil, err
+	}
+
+	if v := c.Query("from"); v != "" {
+		from, _, err := parseDateParam(v)
+		if err != nil {
+			return nil, err
+		}
+		q.From = &from
+	}
+	if v := c.Query("to"); v != "" {
+		to, dateOnly, err := parseDateParam(v)
+		if err != nil {
+			return nil, err
+		}
+		if dateOnly { // дата без времени - включаем весь день
+			to = to.AddDate(0, 0, 1)
+		} else {
+			to = to.Add(time.Nanosecond)
+		}
+		q.To = &to
+	}
+
+	if q.Type != "" && q.Type != "income" && q.Type != "expense" && q.Type != "transfer" {
+		return nil, errors.New("type must be 'income', 'expense' or 'transfer'")
+	}
+
+	if v := c.Query("tags"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				return nil, errors.New("tags must be a comma-separated list of tag IDs")
+			}
+			if !slices.Contains(q.Tags, uint(id)) {
+				q.Tags = append(q.Tags, uint(id))
+			}
+		}
+	}
+	switch c.Query("tag_mode", "any") {
+	case "any":
+		q.AllTags = false
+	case "all":
+		q.AllTags = true
+	default:
+		return nil, errors.New("tag_mode must be 'any' or 'all'")
+	}
+
+	if q.MinAmount, err = parseAmountParam(c, "min_amount"); err != nil {
+		return nil, err
+	}
+	if q.MaxAmount, err = parseAmountParam(c, "max_amount"); err != nil {
+		return nil, err
+	}
+
+	if _, ok := transactionSortFields[q.Sort]; !ok {
+		return nil, errors.New("sort must be one of: date, amount, created_at")
+	}
+	switch c.Query("order", "desc") {
+	case "desc":
+		q.Desc = true
+	case "asc":
+		q.Desc = false
+	default:
+		return nil, errors.New("order must be 'asc' or 'desc'")
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return nil, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+		q.Limit = limit
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		q.Cursor = new(transactionCursor)
+		if err := json.Unmarshal(raw, q.Cursor); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+
+	return q, nil
+}
+
+// parseIDParam читает необязательный идентификатор
+func parseIDParam(c *fiber.Ctx, name string) (*uint, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return nil, errors.New(name + " must be a number")
+	}
+	result := uint(id)
+	return &result, nil
+}
+
+// parseAmountParam читает необязательный числовой параметр
+func parseAmountParam(c *fiber.Ctx, name string) (*Money, error) {
+	v := c.Query(name)
+	if v == "" {
+		return nil, nil
+	}
+	amount, err := ParseMoney(v)
+	if err != nil {
+		return nil, errors.New(name + " must be a decimal number")
+	}
+	return &amount, nil
+}
+
+// Filter накладывает фильтры (без сортировки и пагинации) на запрос
+func (q *transactionQuery) Filter(tx *gorm.DB) *gorm.DB {
+	if q.From != nil {
+		tx = tx.Where("date >= ?", *q.From)
+	}
+	if q.To != nil {
+		tx = tx.Where("date < ?", *q.To)
+	}
+	if q.Type != "" {
+		tx = tx.Where("type = ?", q.Type)
+	}
+	if q.Category != nil {
+		// транзакции с разбивкой попадают в выборку, если в категории хотя бы одна часть
+		tx = tx.Where("(category_id IN ("+categoryTreeSQL+") OR id IN (SELECT transaction_id FROM transaction_splits WHERE category_id IN ("+categoryTreeSQL+")))",
+			*q.Category, *q.Category)
+	}
+	if q.Account != nil {
+		tx = tx.Where("(account_id = ? OR to_account_id = ?)", *q.Account, *q.Account)
+	}
+	if q.MinAmount != nil {
+		tx = tx.Where("amount >= ?", *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		tx = tx.Where("amount <= ?", *q.MaxAmount)
+	}
+	if q.Search != "" {
+		tx = tx.Where("description ILIKE ?", "%"+escapeLike(q.Search)+"%")
+	}
+	if len(q.Tags) > 0 {
+		if q.AllTags {
+			tx = tx.Where("id IN (SELECT transaction_id FROM transaction_tags WHERE tag_id IN ? GROUP BY transaction_id HAVING COUNT(*) = ?)", q.Tags, len(q.Tags))
+		} else {
+			tx = tx.Where("id IN (SELECT transaction_id FROM transaction_tags WHERE tag_id IN ?)", q.Tags)
+		}
+	}
+	return tx
+}
+
+// Order задает сортировку; id добавляется для стабильного порядка
+func (q *transactionQuery) Order(tx *gorm.DB) *gorm.DB {
+	dir := "ASC"
+	if q.Desc {
+		dir = "DESC"
+	}
+	column := transactionSortFields[q.Sort]
+	return tx.Order(column + " " + dir).Order("id " + dir)
+}
+
+// Paginate продолжает выборку с позиции курсора и ограничивает размер страницы.
+// Запрашивается на одну запись больше, чтобы понять, есть ли следующая страница
+func (q *transactionQuery) Paginate(tx *gorm.DB) (*gorm.DB, error) {
+	if q.Cursor != nil {
+		value, err := q.cursorValue(q.Cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+		column := transactionSortFields[q.Sort]
+		op := ">"
+		if q.Desc {
+			op = "<"
+		}
+		tx = tx.Where("("+column+" "+op+" ?) OR ("+column+" = ? AND id "+op+" ?)", value, value, q.Cursor.ID)
+	}
+	return q.Order(tx).Limit(q.Limit + 1), nil
+}
+
+// cursorValue переводит значение из курсора в тип колонки сортировки
+func (q *transactionQuery) cursorValue(v string) (interface{}, error) {
+	if q.Sort == "amount" {
+		amount, err := ParseMoney(v)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		return amount, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return t, nil
+}
+
+// NextCursor строит курсор по последней записи страницы
+func (q *transactionQuery) NextCursor(last Transaction) string {
+	cursor := transactionCursor{ID: last.ID}
+	switch q.Sort {
+	case "amount":
+		cursor.Value = last.Amount.String()
+	case "created_at":
+		cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = last.Date.Format(time.RFC3339Nano)
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
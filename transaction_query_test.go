@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// withQuery вызывает f с контекстом запроса GET с заданной query-строкой
+func withQuery(t *testing.T, query string, f func(c *fiber.Ctx)) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		f(c)
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest("GET", "/?"+query, nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// parseQuery разбирает параметры списка транзакций из query-строки
+func parseQuery(t *testing.T, query string) (q *transactionQuery, err error) {
+	t.Helper()
+	withQuery(t, query, func(c *fiber.Ctx) { q, err = parseTransactionQuery(c) })
+	return q, err
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "coffee", want: "coffee"},
+		{in: "100%", want: `100\%`},
+		{in: "a_b", want: `a\_b`},
+		{in: `C:\dir`, want: `C:\\dir`},
+		{in: `\%_`, want: `\\\%\_`},
+		{in: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := escapeLike(tt.in); got != tt.want {
+			t.Errorf("escapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDateParam(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     time.Time
+		dateOnly bool
+		wantErr  bool
+	}{
+		{in: "2026-03-01", want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), dateOnly: true},
+		{in: "2026-03-01T10:30:00Z", want: time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{in: "2026-03-01T10:30:00+03:00", want: time.Date(2026, 3, 1, 7, 30, 0, 0, time.UTC)},
+		{in: "01.03.2026", wantErr: true},
+		{in: "2026-02-30", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, dateOnly, err := parseDateParam(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDateParam(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) || dateOnly != tt.dateOnly {
+			t.Errorf("parseDateParam(%q) = %v, %v, %v, want %v, %v", tt.in, got, dateOnly, err, tt.want, tt.dateOnly)
+		}
+	}
+}
+
+func TestParseTransactionQuery(t *testing.T) {
+	q, err := parseQuery(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Sort != "date" || !q.Desc || q.Limit != defaultPageSize || q.From != nil || q.To != nil || q.Cursor != nil {
+		t.Errorf("defaults = %+v", q)
+	}
+
+	q, err = parseQuery(t, "from=2026-03-01&to=2026-03-31&type=expense&q=+coffee+&sort=amount&order=asc&limit=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.From.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("From = %v", q.From)
+	}
+	if !q.To.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) { // дата без времени включает весь день
+		t.Errorf("To = %v", q.To)
+	}
+	if q.Type != "expense" || q.Search != "coffee" || q.Sort != "amount" || q.Desc || q.Limit != 10 {
+		t.Errorf("parsed = %+v", q)
+	}
+
+	q, err = parseQuery(t, "to=2026-03-31T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.To.Equal(time.Date(2026, 3, 31, 12, 0, 0, 1, time.UTC)) { // время включается в период
+		t.Errorf("To with time = %v", q.To)
+	}
+
+	for _, query := range []string{
+		"from=yesterday",
+		"type=refund",
+		"sort=description",
+		"order=up",
+		"limit=0",
+		"limit=201",
+		"limit=ten",
+		"min_amount=abc",
+		"min_amount=1.005",
+		"category_id=food",
+		"account_id=-1",
+		"tags=1,x",
+		"tag_mode=some",
+		"cursor=%%%",
+		"cursor=bm90IGpzb24",
+	} {
+		if _, err := parseQuery(t, query); err == nil {
+			t.Errorf("parseTransactionQuery(%s): want error", query)
+		}
+	}
+}
+
+func TestTransactionCursor(t *testing.T) {
+	last := Transaction{
+		ID:     42,
+		Amount: 123450,
+		Date:   time.Date(2026, 3, 5, 14, 0, 0, 123, time.UTC),
+	}
+	last.CreatedAt = time.Date(2026, 3, 6, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		sort string
+		want interface{}
+	}{
+		{sort: "date", want: last.Date},
+		{sort: "created_at", want: last.CreatedAt},
+		{sort: "amount", want: Money(123450)},
+	}
+	for _, tt := range tests {
+		cursor := (&transactionQuery{Sort: tt.sort}).NextCursor(last)
+		q, err := parseQuery(t, "sort="+tt.sort+"&cursor="+cursor)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.sort, err)
+		}
+		if q.Cursor.ID != last.ID {
+			t.Errorf("%s: cursor ID = %d, want %d", tt.sort, q.Cursor.ID, last.ID)
+		}
+		value, err := q.cursorValue(q.Cursor.Value)
+		if err != nil {
+			t.Fatalf("%s: cursorValue: %v", tt.sort, err)
+		}
+		if want, ok := tt.want.(time.Time); ok {
+			if !value.(time.Time).Equal(want) {
+				t.Errorf("%s: cursor value = %v, want %v", tt.sort, value, want)
+			}
+		} else if value != tt.want {
+			t.Errorf("%s: cursor value = %v, want %v", tt.sort, value, tt.want)
+		}
+	}
+
+	// значение курсора не подходит к полю сортировки
+	if _, err := (&transactionQuery{Sort: "amount"}).cursorValue("2026-03-05T14:00:00Z"); err == nil {
+		t.Error("amount cursor with a date: want error")
+	}
+	if _, err := (&transactionQuery{Sort: "date"}).cursorValue("1234.50"); err == nil {
+		t.Error("date cursor with an amount: want error")
+	}
+}
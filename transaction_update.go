@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// errVersionConflict - транзакцию изменили после того, как клиент ее загрузил
+var errVersionConflict = errors.New("Transaction was modified by another request, reload it and retry")
+
+// колонки, которые меняются при обновлении транзакции; разбивка и теги сохраняются отдельно
+var transactionUpdateColumns = []string{"Amount", "Currency", "Type", "CategoryID", "AccountID", "Description", "Date", "Version"}
+
+// validateTransaction - общие проверки при создании, полном и частичном обновлении транзакции.
+// Валюту без счета нужно заполнить до вызова; имена новых тегов заменяются тегами без ID
+func validateTransaction(ledgerID uint, transaction *Transaction) error {
+	if transaction.Type == "" || transaction.Amount == 0 {
+		return errors.New("Type and Amount are required")
+	}
+	if transaction.Amount < 0 { // направление задает Type, сумма всегда положительная
+		return errors.New("Amount must be positive")
+	}
+	if transaction.Type != "income" && transaction.Type != "expense" {
+		return errors.New("Type must be 'income' or 'expense'")
+	}
+	if transaction.Date.IsZero() {
+		return errors.New("Date is required")
+	}
+
+	// Если указан счет, валюта берется из него
+	if err := transactionAccount(ledgerID, transaction); err != nil {
+		return err
+	}
+	if transaction.Currency == "" {
+		return errors.New("Currency is required")
+	}
+	currency, err := normalizeCurrency(transaction.Currency)
+	if err != nil {
+		return err
+	}
+	transaction.Currency = currency
+
+	transaction.Category = nil // категория задается только через CategoryID
+	if err := validateTransactionCategory(ledgerID, transaction.CategoryID, transaction.Type); err != nil {
+		return err
+	}
+	if err := validateSplits(ledgerID, transaction); err != nil {
+		return err
+	}
+	transaction.Tags, err = resolveTags(ledgerID, transaction.Tags)
+	return err
+}
+
+// transactionETag - ETag транзакции по ее версии
+func transactionETag(transaction *Transaction) string {
+	return `"` + strconv.FormatUint(uint64(transaction.Version), 10) + `"`
+}
+
+// ifMatch проверяет заголовок If-Match. Без заголовка запрос выполняется всегда,
+// "*" совпадает с любой версией; слабые ETag (W/"...") не совпадают, как требует RFC 9110
+func ifMatch(c *fiber.Ctx, transaction *Transaction) bool {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		return true
+	}
+	etag := transactionETag(transaction)
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimSpace(v); v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePatch применяет JSON Merge Patch (RFC 7396): null удаляет поле, объекты сливаются
+// рекурсивно, остальные значения, в том числе массивы, заменяются целиком. Имена полей
+// сравниваются без учета регистра, как при разборе JSON в структуру
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		for existing := range targetObject {
+			if strings.EqualFold(existing, name) {
+				name = existing
+				break
+			}
+		}
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// decodeJSON разбирает JSON с числами в виде json.Number, чтобы суммы не теряли точность
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// updateTransaction переносит в транзакцию поля из updated, проверяет и сохраняет ее для PUT и PATCH.
+// Запись обновляется, только если ее версия не изменилась с момента загрузки before
+func updateTransaction(c *fiber.Ctx, before, updated *Transaction) error {
+	if before.Type == "transfer" { // у перевода два счета и две суммы, он создается через /api/transfers
+		return c.Status(400).JSON(fiber.Map{"error": "Transfers cannot be edited, delete the transfer and create a new one"})
+	}
+	if updated.Version != 0 && updated.Version != before.Version { // версия из тела запроса проверяется так же, как If-Match
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": errVersionConflict.Error()})
+	}
+
+	transaction := *before // разбивка и теги ниже заменяются новыми срезами, before не меняется
+	transaction.Amount = updated.Amount
+	transaction.Type = updated.Type
+	transaction.CategoryID = updated.CategoryID
+	transaction.Description = updated.Description
+	transaction.Date = updated.Date
+	if updated.Currency != "" { // валюта меняется, только если передана
+		transaction.Currency = updated.Currency
+	}
+	transaction.AccountID = updated.AccountID
+	transaction.Splits = updated.Splits // разбивка заменяется целиком, пустая убирает ее
+	transaction.Tags = updated.Tags     // теги тоже заменяются целиком
+	if err := validateTransaction(before.LedgerID, &transaction); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	transaction.Version = before.Version + 1
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&transaction).Where("version = ?", before.Version).Select(transactionUpdateColumns).Updates(&transaction)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 { // транзакцию успели изменить или удалить
+			return errVersionConflict
+		}
+		if err := tx.Where("transaction_id = ?", transaction.ID).Delete(&TransactionSplit{}).Error; err != nil {
+			return err
+		}
+		for i := range transaction.Splits {
+			transaction.Splits[i].TransactionID = transaction.ID
+		}
+		if len(transaction.Splits) > 0 {
+			if err := tx.Create(&transaction.Splits).Error; err != nil {
+				return err
+			}
+		}
+		for i := range transaction.Tags {
+			if transaction.Tags[i].ID == 0 {
+				if err := tx.Create(&transaction.Tags[i]).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Model(&transaction).Association("Tags").Replace(transaction.Tags); err != nil {
+			return err
+		}
+		return recordRevision(tx, requestActor(c), revisionUpdate, before, &transaction)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set(fiber.HeaderETag, transactionETag(&transaction))
+	return c.JSON(transaction)
+}
+
+// @Summary Get a transaction
+// @Description Get a transaction by ID with its category, splits and tags. The ETag header holds the version to send in If-Match when updating or deleting it
+// @Tags transactions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Success 200 {object} Transaction "Transaction"
+// @Header 200 {string} ETag "Transaction version"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Transaction not found"
+// @Router /api/transactions/{id} [get]
+func GetTransactionByID(c *fiber.Ctx) error {
+	var transaction Transaction
+	err := db.Preload("Category").
+		Preload("Splits", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Splits.Category").
+		Preload("Tags", func(tx *gorm.DB) *gorm.DB { return tx.Order("name") }).
+		Where("id = ? AND ledger_id = ?", c.Params("id"), c.Locals("ledger_id").(uint)).
+		First(&transaction).Error
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+	c.Set(fiber.HeaderETag, transactionETag(&transaction))
+	return c.JSON(transaction)
+}
+
+// @Summary Partially update a transaction
+// @Description Change only the fields present in the body using JSON Merge Patch (RFC 7396): null clears a field, Splits and Tags are replaced as a whole. The result is validated the same way as a new transaction. Transfers cannot be edited. Send the ETag in If-Match to make sure concurrent changes are not overwritten
+// @Tags transactions
+// @Accept json
+// @Accept application/merge-patch+json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Param If-Match header string false "ETag of the transaction the changes are based on"
+// @Param patch body Transaction true "Fields to change"
+// @Success 200 {object} Transaction "Updated transaction"
+// @Header 200 {string} ETag "New transaction version"
+// @Failure 400 {object} map[string]string "Invalid patch or resulting transaction"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Transaction not found"
+// @Failure 412 {object} map[string]string "Transaction was modified by another request"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/transactions/{id} [patch]
+func PatchTransaction(c *fiber.Ctx) error {
+	before, err := loadTransactionState(db, c.Params("id"), c.Locals("ledger_id").(uint)) // состояние до изменения для истории
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found"})
+	}
+	if !ifMatch(c, before) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": errVersionConflict.Error()})
+	}
+
+	patch, err := decodeJSON(c.Body())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON: " + err.Error()})
+	}
+	if _, ok := patch.(map[string]interface{}); !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Patch must be a JSON object"})
+	}
+
+	// патч применяется к текущему состоянию в JSON, результат разбирается как тело PUT
+	current, err := json.Marshal(before)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	target, err := decodeJSON(current)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	updated := new(Transaction)
+	if err := json.Unmarshal(merged, updated); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return updateTransaction(c, before, updated)
+}
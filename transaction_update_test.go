@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name                string
+		target, patch, want string
+	}{
+		// RFC 7396, приложение A
+		{name: "replace", target: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "add", target: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{name: "remove", target: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{name: "remove one of two", target: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{name: "array replaced", target: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "value replaced by array", target: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{name: "nested", target: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{name: "array of objects", target: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{name: "arrays not merged", target: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{name: "object replaced by array", target: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{name: "object replaced by null", target: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{name: "object replaced by string", target: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+		{name: "null in new object", target: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+		{name: "array target", target: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{name: "deep null dropped", target: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+
+		// поля транзакции
+		{name: "key case ignored", target: `{"Amount":"10.00","Type":"expense"}`, patch: `{"amount":"12.50"}`, want: `{"Amount":"12.50","Type":"expense"}`},
+		{name: "null clears field", target: `{"CategoryID":3,"Description":"x"}`, patch: `{"categoryid":null}`, want: `{"Description":"x"}`},
+		{name: "tags replaced", target: `{"Tags":[{"ID":1},{"ID":2}]}`, patch: `{"tags":[{"Name":"trip"}]}`, want: `{"Tags":[{"Name":"trip"}]}`},
+		{name: "empty splits", target: `{"Splits":[{"Amount":"5.00"}]}`, patch: `{"Splits":[]}`, want: `{"Splits":[]}`},
+		{name: "nested key case", target: `{"Category":{"Name":"Food"}}`, patch: `{"category":{"name":"Cafe"}}`, want: `{"Category":{"Name":"Cafe"}}`},
+		{name: "empty patch", target: `{"Amount":"10.00"}`, patch: `{}`, want: `{"Amount":"10.00"}`},
+	}
+	for _, tt := range tests {
+		target, err := decodeJSON([]byte(tt.target))
+		if err != nil {
+			t.Fatalf("%s: target: %v", tt.name, err)
+		}
+		patch, err := decodeJSON([]byte(tt.patch))
+		if err != nil {
+			t.Fatalf("%s: patch: %v", tt.name, err)
+		}
+		want, err := decodeJSON([]byte(tt.want))
+		if err != nil {
+			t.Fatalf("%s: want: %v", tt.name, err)
+		}
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: mergePatch(%s, %s) = %v, want %s", tt.name, tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	transaction := &Transaction{Version: 3}
+	if got := transactionETag(transaction); got != `"3"` {
+		t.Fatalf("transactionETag = %s, want \"3\"", got)
+	}
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "", want: true},
+		{header: "*", want: true},
+		{header: `"3"`, want: true},
+		{header: `"1", "3"`, want: true},
+		{header: `"2"`, want: false},
+		{header: `W/"3"`, want: false}, // слабый ETag не совпадает
+		{header: `3`, want: false},
+	}
+	for _, tt := range tests {
+		var got bool
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			got = ifMatch(c, transaction)
+			return nil
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set(fiber.HeaderIfMatch, tt.header)
+		}
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ifMatch(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	trashPurgeInterval    = time.Hour
+	trashPurgeBatch       = 100
+)
+
+// trashRetention - сколько удаленные транзакции хранятся в корзине (TRASH_RETENTION, по умолчанию 30 дней)
+func trashRetention() time.Duration {
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err == nil && retention > 0 {
+			return retention
+		}
+		log.Println("Корзина: некорректный TRASH_RETENTION, используется", defaultTrashRetention)
+	}
+	return defaultTrashRetention
+}
+
+// @Summary Get deleted transactions
+// @Description Transactions of the current ledger in the trash. They are excluded from the transaction list, balance and reports, can be restored and are permanently deleted after the retention period (30 days by default). Accepts the same filters, sorting and pagination as the transaction list
+// @Tags transactions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param from query string false "Start date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date inclusive (YYYY-MM-DD or RFC3339)"
+// @Param type query string false "Transaction type" Enums(income, expense, transfer)
+// @Param account_id query int false "Account ID, transfers from and to the account are included"
+// @Param category_id query int false "Category ID, subcategories are included"
+// @Param min_amount query number false "Minimum amount"
+// @Param max_amount query number false "Maximum amount"
+// @Param q query string false "Search in description"
+// @Param tags query string false "Comma-separated tag IDs"
+// @Param tag_mode query string false "Match transactions with any or all of the tags" Enums(any, all) default(any)
+// @Param sort query string false "Sort field" Enums(date, amount, created_at) default(date)
+// @Param order query string false "Sort direction" Enums(asc, desc) default(desc)
+// @Param limit query int false "Page size (1-200)" default(50)
+// @Param cursor query string false "Cursor from next_cursor of the previous page"
+// @Success 200 {object} transactionPage "Page of deleted transactions"
+// @Failure 400 {object} map[string]string "Invalid query parameters"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/trash [get]
+func GetTrash(c *fiber.Ctx) error {
+	query, err := parseTransactionQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	trashed := db.Unscoped().
+		Preload("Category").
+		Preload("Splits", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Preload("Tags", func(tx *gorm.DB) *gorm.DB { return tx.Order("name") }).
+		Where("ledger_id = ? AND deleted_at IS NOT NULL", c.Locals("ledger_id").(uint))
+	tx, err := query.Paginate(query.Filter(trashed))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var transactions []Transaction
+	if err := tx.Find(&transactions).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	page := transactionPage{Items: transactions}
+	if len(transactions) > query.Limit { //есть следующая страница
+		page.Items = transactions[:query.Limit]
+		page.NextCursor = query.NextCursor(page.Items[query.Limit-1])
+	}
+	return c.JSON(page)
+}
+
+// @Summary Restore a deleted transaction
+// @Description Move a transaction from the trash back to the ledger with its splits, tags and attachments
+// @Tags transactions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "Transaction ID"
+// @Success 200 {object} Transaction "Restored transaction"
+// @Header 200 {string} ETag "Transaction version"
+// @Failure 401 {object} map[string]interface{} "Unauthorized"
+// @Failure 404 {object} map[string]string "Transaction not found in the trash"
+// @Failure 412 {object} map[string]string "Transaction was restored or purged by another request"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /api/transactions/{id}/restore [post]
+func RestoreTransaction(c *fiber.Ctx) error {
+	before, err := loadTransactionState(db.Unscoped().Where("deleted_at IS NOT NULL"), c.Params("id"), c.Locals("ledger_id").(uint))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Transaction not found in the trash"})
+	}
+	transaction := *before
+	transaction.DeletedAt = gorm.DeletedAt{}
+	transaction.Version++
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Model(&Transaction{}).Where("id = ? AND version = ?", transaction.ID, before.Version).
+			Updates(map[string]interface{}{"deleted_at": nil, "version": transaction.Version})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 { // транзакцию успели восстановить или удалить окончательно
+			return errVersionConflict
+		}
+		return recordRevision(tx, requestActor(c), revisionRestore, before, &transaction)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderETag, transactionETag(&transaction))
+	return c.JSON(transaction)
+}
+
+// purgeTrash окончательно удаляет транзакции, пролежавшие в корзине дольше срока хранения,
+// вместе с разбивкой, тегами и вложениями. Файлы вложений удаляются после записи в бд
+func purgeTrash(now time.Time) {
+	cutoff := now.Add(-trashRetention())
+	for {
+		var keys []string
+		var purged int
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var transactions []Transaction
+			// транзакции, которые удаляет другой экземпляр сервера, пропускаем
+			err := tx.Unscoped().
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Preload("Splits", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+				Preload("Tags", func(tx *gorm.DB) *gorm.DB { return tx.Order("name") }).
+				Where("deleted_at < ?", cutoff).
+				Order("id").
+				Limit(trashPurgeBatch).
+				Find(&transactions).Error
+			if err != nil || len(transactions) == 0 {
+				return err
+			}
+			ids := make([]uint, len(transactions))
+			for i := range transactions {
+				ids[i] = transactions[i].ID
+			}
+			if err := tx.Model(&Attachment{}).Where("transaction_id IN ?", ids).Pluck("storage_key", &keys).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&Transaction{}, ids).Error; err != nil { //части, теги и вложения удаляются каскадно
+				return err
+			}
+			for i := range transactions {
+				if err := recordRevision(tx, revisionActor{}, revisionPurge, &transactions[i], nil); err != nil {
+					return err
+				}
+			}
+			purged = len(transactions)
+			return nil
+		})
+		if err != nil {
+			log.Println("Корзина: ошибка очистки:", err)
+			return
+		}
+		deleteBlobs(keys)
+		if purged < trashPurgeBatch {
+			return
+		}
+	}
+}
+
+// runTrashPurger периодически очищает корзину; первый проход выполняется сразу
+func runTrashPurger() {
+	purgeTrash(time.Now())
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		purgeTrash(now)
+	}
+}
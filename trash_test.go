@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashRetention(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: defaultTrashRetention},
+		{value: "168h", want: 7 * 24 * time.Hour},
+		{value: "90m", want: 90 * time.Minute},
+		{value: "0s", want: defaultTrashRetention},
+		{value: "-1h", want: defaultTrashRetention},
+		{value: "30d", want: defaultTrashRetention}, // дни time.ParseDuration не понимает
+	}
+	for _, tt := range tests {
+		t.Setenv("TRASH_RETENTION", tt.value)
+		if got := trashRetention(); got != tt.want {
+			t.Errorf("trashRetention(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}